@@ -0,0 +1,142 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package cli
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"go.dev.pztrn.name/opensaps/context"
+)
+
+// Exit codes for subcommands.
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+// Subcommand describes command which can be executed instead of
+// launching OpenSAPS daemon.
+type subcommand struct {
+	// Handler executes command and returns process exit code.
+	handler func(args []string) int
+	// Usage is a short usage description.
+	usage string
+	// Options are subcommand's own flags. Everything else that looks
+	// like a flag will be passed to flagger.
+	options []string
+}
+
+var ctx *context.Context
+
+// Returns all known subcommands.
+func getSubcommands() map[string]subcommand {
+	return map[string]subcommand{
+		"dlq": {
+			handler: dlqCommand,
+			usage:   "dlq list | dlq show ID | dlq replay ID|--all",
+			options: []string{"--all"},
+		},
+		"validate": {
+			handler: validateCommand,
+			usage:   "validate",
+			options: []string{},
+		},
+	}
+}
+
+// ExtractSubcommand looks for subcommand in command line arguments and
+// removes it (with it's arguments) from os.Args, so only flags will be
+// left for flagger. Subcommand should be first argument, e.g.:
+//
+//	opensaps dlq list -config /path/to/opensaps.yaml
+//
+// Returns empty string if no subcommand was passed.
+func ExtractSubcommand() (string, []string) {
+	// nolint:gomnd
+	if len(os.Args) < 2 {
+		return "", nil
+	}
+
+	name := os.Args[1]
+
+	cmd, found := getSubcommands()[name]
+	if !found {
+		return "", nil
+	}
+
+	args := make([]string, 0)
+	idx := 2
+
+	for ; idx < len(os.Args); idx++ {
+		arg := os.Args[idx]
+		if strings.HasPrefix(arg, "-") && !isOption(cmd, arg) {
+			break
+		}
+
+		args = append(args, arg)
+	}
+
+	os.Args = append([]string{os.Args[0]}, os.Args[idx:]...)
+
+	return name, args
+}
+
+// Run executes subcommand and returns process exit code.
+func Run(cc *context.Context, name string, args []string) int {
+	ctx = cc
+
+	cmd, found := getSubcommands()[name]
+	if !found {
+		printUsage()
+
+		return exitUsage
+	}
+
+	return cmd.handler(args)
+}
+
+func isOption(cmd subcommand, arg string) bool {
+	for _, option := range cmd.options {
+		if option == arg {
+			return true
+		}
+	}
+
+	return false
+}
+
+func printUsage() {
+	subcommands := getSubcommands()
+
+	names := make([]string, 0, len(subcommands))
+	for name := range subcommands {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "Available subcommands:")
+
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "    opensaps %s\n", subcommands[name].usage)
+	}
+}
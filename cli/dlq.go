@@ -0,0 +1,173 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"go.dev.pztrn.name/opensaps/queue"
+)
+
+// Maximum length of last error shown in messages list.
+const dlqListErrorLength = 60
+
+// Handles "dlq" subcommand which allows to inspect and replay messages
+// which delivery has failed.
+func dlqCommand(args []string) int {
+	if len(args) == 0 {
+		printUsage()
+
+		return exitUsage
+	}
+
+	ctx.Config.LoadConfigurationFromFile()
+
+	cfg := ctx.Config.GetConfig()
+	if cfg.Queue.Directory == "" {
+		fmt.Fprintln(os.Stderr, "Delivery queue isn't configured, there are no dead letters.")
+
+		return exitError
+	}
+
+	storage, err := queue.NewStorage(cfg.Queue.Directory)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open delivery queue: %s\n", err.Error())
+
+		return exitError
+	}
+
+	storage.OnCorrupt = func(path string, err error) {
+		fmt.Fprintf(os.Stderr, "Failed to read '%s', moved it into corrupt files directory: %s\n", path, err.Error())
+	}
+
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		return dlqList(storage)
+	case args[0] == "show" && len(args) == 2:
+		return dlqShow(storage, args[1])
+	case args[0] == "replay" && len(args) == 2:
+		return dlqReplay(storage, args[1])
+	}
+
+	printUsage()
+
+	return exitUsage
+}
+
+func dlqList(storage *queue.Storage) int {
+	items, err := storage.Dead()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get dead letters: %s\n", err.Error())
+
+		return exitError
+	}
+
+	if len(items) == 0 {
+		fmt.Println("There are no dead letters.")
+
+		return exitOK
+	}
+
+	// nolint:gomnd
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tRECEIVED\tWEBHOOK\tDESTINATION\tATTEMPTS\tLAST ERROR")
+
+	for _, item := range items {
+		lastError := item.LastError
+		if len(lastError) > dlqListErrorLength {
+			lastError = lastError[:dlqListErrorLength] + "..."
+		}
+
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%d\t%s\n", item.ID, item.CreatedAt.Format(time.RFC3339), item.Webhook,
+			item.Destination().String(), item.Attempts, lastError)
+	}
+
+	_ = writer.Flush()
+
+	return exitOK
+}
+
+func dlqShow(storage *queue.Storage, id string) int {
+	item, err := storage.GetDead(id)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get dead letter: %s\n", err.Error())
+
+		return exitError
+	}
+
+	message, err := json.MarshalIndent(item.Message, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to encode message: %s\n", err.Error())
+
+		return exitError
+	}
+
+	fmt.Printf("ID:          %s\n", item.ID)
+	fmt.Printf("Received:    %s\n", item.CreatedAt.Format(time.RFC3339))
+	fmt.Printf("Webhook:     %s\n", item.Webhook)
+	fmt.Printf("Pusher:      %s\n", item.Pusher)
+	fmt.Printf("Connection:  %s\n", item.Connection)
+	fmt.Printf("Attempts:    %d\n", item.Attempts)
+	fmt.Printf("Last error:  %s\n", item.LastError)
+	fmt.Printf("Message:\n%s\n", string(message))
+
+	return exitOK
+}
+
+func dlqReplay(storage *queue.Storage, id string) int {
+	ids := []string{id}
+
+	if id == "--all" {
+		items, err := storage.Dead()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to get dead letters: %s\n", err.Error())
+
+			return exitError
+		}
+
+		ids = make([]string, 0, len(items))
+		for _, item := range items {
+			ids = append(ids, item.ID)
+		}
+	}
+
+	exitCode := exitOK
+
+	for _, id := range ids {
+		item, err := storage.Replay(id)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to replay message '%s': %s\n", id, err.Error())
+
+			exitCode = exitError
+
+			continue
+		}
+
+		fmt.Printf("Message '%s' queued for delivery to '%s'\n", item.ID, item.Destination().String())
+	}
+
+	if len(ids) != 0 && exitCode == exitOK {
+		fmt.Println("Running OpenSAPS will pick replayed messages up shortly or they will be delivered on next start.")
+	}
+
+	return exitCode
+}
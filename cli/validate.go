@@ -0,0 +1,69 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	configurationinterface "go.dev.pztrn.name/opensaps/config/interface"
+)
+
+// Handles "validate" subcommand which checks configuration file and
+// prints every problem found.
+func validateCommand(args []string) int {
+	if len(args) != 0 {
+		printUsage()
+
+		return exitUsage
+	}
+
+	configpath, _ := ctx.Config.GetTempValue("CONFIGURATION_FILE")
+
+	err := ctx.Config.CheckConfigurationFile()
+	if err == nil {
+		fmt.Printf("Configuration file '%s' is valid.\n", configpath)
+
+		return exitOK
+	}
+
+	var validationErrors configurationinterface.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", configpath, err.Error())
+
+		return exitError
+	}
+
+	for _, validationError := range validationErrors {
+		location := configpath
+		if validationError.Line != 0 {
+			location += fmt.Sprintf(":%d", validationError.Line)
+		}
+
+		if validationError.Path != "" {
+			location += ": " + validationError.Path
+		}
+
+		fmt.Fprintf(os.Stderr, "%s: %s\n", location, validationError.Message)
+	}
+
+	fmt.Fprintf(os.Stderr, "Found %d problem(s) in configuration file.\n", len(validationErrors))
+
+	return exitError
+}
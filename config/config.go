@@ -19,19 +19,27 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"os/user"
 	"strings"
 
 	"go.dev.pztrn.name/flagger"
+	configurationinterface "go.dev.pztrn.name/opensaps/config/interface"
 	configstruct "go.dev.pztrn.name/opensaps/config/struct"
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
 )
 
 type Configuration struct{}
 
-// Returns configuration to caller.
+// Returns configuration to caller. Returned structure should be treated
+// as read-only as it might be used by other goroutines. Configuration
+// reloading replaces whole structure, so callers that keep returned
+// pointer will continue to see configuration they started with.
 func (conf Configuration) GetConfig() *configstruct.ConfigStruct {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
 	return config
 }
 
@@ -95,6 +103,19 @@ func (conf Configuration) InitializeLater() {
 	conf.initializeConfigurationFilePath()
 }
 
+// Checks configuration file for mistakes without applying it.
+// Returns configurationinterface.ValidationErrors if mistakes was found.
+func (conf Configuration) CheckConfigurationFile() error {
+	configpath, err := conf.GetTempValue("CONFIGURATION_FILE")
+	if err != nil {
+		return err
+	}
+
+	_, err1 := conf.parseConfigurationFile(configpath)
+
+	return err1
+}
+
 // Loads configuration from file.
 func (conf Configuration) LoadConfigurationFromFile() {
 	configpath, err := conf.GetTempValue("CONFIGURATION_FILE")
@@ -105,21 +126,116 @@ func (conf Configuration) LoadConfigurationFromFile() {
 
 	ctx.Log.Info().Msgf("Loading configuration from '%s'...", configpath)
 
+	newConfig, err1 := conf.parseConfigurationFile(configpath)
+	if err1 != nil {
+		var validationErrors configurationinterface.ValidationErrors
+		if errors.As(err1, &validationErrors) {
+			for _, validationError := range validationErrors {
+				ctx.Log.Error().Msgf("Configuration error: %s", validationError.Error())
+			}
+
+			ctx.Log.Fatal().Msg("Configuration file is invalid, see errors above")
+		}
+
+		ctx.Log.Fatal().Err(err1).Msg("Failed to load configuration")
+	}
+
+	configMutex.Lock()
+	config = newConfig
+	configMutex.Unlock()
+
+	ctx.Log.Debug().Msgf("Loaded configuration: %+v", newConfig)
+}
+
+// Parses configuration file into new configuration structure. Returned
+// error will be configurationinterface.ValidationErrors if file was read
+// but contains mistakes.
+func (conf Configuration) parseConfigurationFile(configpath string) (*configstruct.ConfigStruct, error) {
 	// Read file into memory.
 	configBytes, err1 := ioutil.ReadFile(configpath)
 	if err1 != nil {
-		ctx.Log.Fatal().Msgf("Error occurred while reading configuration file: %s", err1.Error())
+		return nil, fmt.Errorf("error occurred while reading configuration file: %w", err1)
+	}
+
+	// Parse YAML. Nodes tree is kept to figure out where mistakes was made.
+	// nolint:exhaustruct
+	root := &yaml.Node{}
+	if err2 := yaml.Unmarshal(configBytes, root); err2 != nil {
+		return nil, configurationinterface.ValidationErrors{conf.yamlErrorToValidationError(err2.Error())}
+	}
+
+	// nolint:exhaustruct
+	newConfig := &configstruct.ConfigStruct{}
+
+	if err3 := root.Decode(newConfig); err3 != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err3, &typeErr) {
+			return nil, configurationinterface.ValidationErrors{conf.yamlErrorToValidationError(err3.Error())}
+		}
+
+		validationErrors := make(configurationinterface.ValidationErrors, 0, len(typeErr.Errors))
+		for _, message := range typeErr.Errors {
+			validationErrors = append(validationErrors, conf.yamlErrorToValidationError(message))
+		}
+
+		return nil, validationErrors
 	}
 
 	// nolint:exhaustruct
-	config = &configstruct.ConfigStruct{}
-	// Parse YAML.
-	err2 := yaml.Unmarshal(configBytes, config)
-	if err2 != nil {
-		ctx.Log.Fatal().Msgf("Failed to parse configuration file: %s", err2.Error())
+	v := &validator{root: root}
+	v.resolveSecrets(newConfig)
+	conf.registerSecrets(newConfig)
+
+	if validationErrors := v.validate(newConfig); len(validationErrors) != 0 {
+		return nil, validationErrors
 	}
 
-	ctx.Log.Debug().Msgf("Loaded configuration: %+v", config)
+	newConfig.IndexWebhooks()
+
+	return newConfig, nil
+}
+
+// Converts YAML parser error message (like "yaml: line 3: did not find
+// expected key") into validation error.
+func (conf Configuration) yamlErrorToValidationError(message string) configurationinterface.ValidationError {
+	// nolint:exhaustruct
+	validationError := configurationinterface.ValidationError{}
+
+	message = strings.TrimPrefix(message, "yaml: ")
+
+	var line int
+	if _, err := fmt.Sscanf(message, "line %d:", &line); err == nil {
+		validationError.Line = line
+		message = strings.TrimSpace(message[strings.Index(message, ":")+1:])
+	}
+
+	validationError.Message = message
+
+	return validationError
+}
+
+// Re-reads configuration file and replaces current configuration if
+// new one is valid. Current configuration stays untouched on error.
+func (conf Configuration) ReloadConfigurationFromFile() error {
+	configpath, err := conf.GetTempValue("CONFIGURATION_FILE")
+	if err != nil {
+		return err
+	}
+
+	ctx.Log.Info().Msgf("Reloading configuration from '%s'...", configpath)
+
+	newConfig, err1 := conf.parseConfigurationFile(configpath)
+	if err1 != nil {
+		return err1
+	}
+
+	configMutex.Lock()
+	config = newConfig
+	configMutex.Unlock()
+
+	ctx.Log.Debug().Msgf("Reloaded configuration: %+v", newConfig)
+
+	return nil
 }
 
 // Sets value to key in temporary configuration storage.
@@ -18,6 +18,8 @@
 package config
 
 import (
+	"sync"
+
 	configurationinterface "go.dev.pztrn.name/opensaps/config/interface"
 	configstruct "go.dev.pztrn.name/opensaps/config/struct"
 	"go.dev.pztrn.name/opensaps/context"
@@ -28,7 +30,8 @@ var (
 	// Temporary configuration.
 	tempconfig map[string]string
 	// Configuration from YAML file.
-	config *configstruct.ConfigStruct
+	config      *configstruct.ConfigStruct
+	configMutex sync.RWMutex
 )
 
 func New(cc *context.Context) {
@@ -18,14 +18,55 @@
 package configurationinterface
 
 import (
+	"fmt"
+	"strings"
+
 	configstruct "go.dev.pztrn.name/opensaps/config/struct"
 )
 
 type ConfigurationInterface interface {
+	CheckConfigurationFile() error
 	GetConfig() *configstruct.ConfigStruct
 	GetTempValue(key string) (string, error)
 	Initialize()
 	InitializeLater()
 	LoadConfigurationFromFile()
+	ReloadConfigurationFromFile() error
 	SetTempValue(key, value string)
 }
+
+// ValidationError describes single problem found in configuration file.
+type ValidationError struct {
+	// Path is a path to problematic value, e.g. "webhooks.gitea.remote[0].pusher".
+	// Empty if problem isn't related to specific value.
+	Path    string
+	Message string
+	// Line is a line number in configuration file. Zero if unknown.
+	Line int
+}
+
+func (ve ValidationError) Error() string {
+	var prefix string
+
+	if ve.Line != 0 {
+		prefix += fmt.Sprintf("line %d: ", ve.Line)
+	}
+
+	if ve.Path != "" {
+		prefix += ve.Path + ": "
+	}
+
+	return prefix + ve.Message
+}
+
+// ValidationErrors is a list of problems found in configuration file.
+type ValidationErrors []ValidationError
+
+func (ves ValidationErrors) Error() string {
+	messages := make([]string, 0, len(ves))
+	for _, ve := range ves {
+		messages = append(messages, ve.Error())
+	}
+
+	return strings.Join(messages, "; ")
+}
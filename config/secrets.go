@@ -0,0 +1,137 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	configstruct "go.dev.pztrn.name/opensaps/config/struct"
+)
+
+// Matches "${ENV_VAR}" references in secret values.
+var envVarRegexp = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Fills secret fields from environment variables and files, so secrets
+// won't be stored in configuration file itself.
+func (v *validator) resolveSecrets(cfg *configstruct.ConfigStruct) {
+	v.unresolved = make(map[string]bool)
+
+	for name, webhook := range cfg.Webhooks {
+		path := []string{"webhooks", name, "slack"}
+		webhook.Slack.Random1 = v.resolveSecret(path, "random1", webhook.Slack.Random1, webhook.Slack.Random1File)
+		webhook.Slack.Random2 = v.resolveSecret(path, "random2", webhook.Slack.Random2, webhook.Slack.Random2File)
+		webhook.Slack.LongRandom = v.resolveSecret(path, "longrandom", webhook.Slack.LongRandom,
+			webhook.Slack.LongRandomFile)
+		webhook.Slack.SigningSecret = v.resolveSecret(path, "signing_secret", webhook.Slack.SigningSecret,
+			webhook.Slack.SigningSecretFile)
+		webhook.Slack.SharedSecret = v.resolveSecret(path, "shared_secret", webhook.Slack.SharedSecret,
+			webhook.Slack.SharedSecretFile)
+		cfg.Webhooks[name] = webhook
+	}
+
+	for name, bot := range cfg.WebAPI.Bots {
+		bot.Token = v.resolveSecret([]string{"webapi", "bots", name}, "token", bot.Token, bot.TokenFile)
+		cfg.WebAPI.Bots[name] = bot
+	}
+
+	for name, conn := range cfg.Matrix {
+		conn.Password = v.resolveSecret([]string{"matrix", name}, "password", conn.Password, conn.PasswordFile)
+		cfg.Matrix[name] = conn
+	}
+
+	for name, conn := range cfg.Telegram {
+		conn.BotID = v.resolveSecret([]string{"telegram", name}, "bot_id", conn.BotID, conn.BotIDFile)
+		conn.Proxy.Password = v.resolveSecret([]string{"telegram", name, "proxy"}, "password", conn.Proxy.Password,
+			conn.Proxy.PasswordFile)
+		cfg.Telegram[name] = conn
+	}
+}
+
+// Returns secret value. Value can be set directly (with "${ENV_VAR}"
+// references expanded) or read from file set in "KEY_file" field.
+func (v *validator) resolveSecret(path []string, key string, value string, file string) string {
+	fileKey := key + "_file"
+
+	if file != "" {
+		if value != "" {
+			v.addError(append(path, fileKey), "only one of '%s' and '%s' should be set", key, fileKey)
+
+			return value
+		}
+
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			v.addError(append(path, fileKey), "failed to read secret: %s", err.Error())
+			v.unresolved[v.formatPath(append(path, key))] = true
+
+			return ""
+		}
+
+		// Files are usually written with trailing newline.
+		return strings.TrimSpace(string(data))
+	}
+
+	unresolved := false
+
+	value = envVarRegexp.ReplaceAllStringFunc(value, func(reference string) string {
+		name := envVarRegexp.FindStringSubmatch(reference)[1]
+
+		envValue, found := os.LookupEnv(name)
+		if !found {
+			v.addError(append(path, key), "environment variable '%s' isn't set", name)
+
+			unresolved = true
+		}
+
+		return envValue
+	})
+
+	if unresolved {
+		v.unresolved[v.formatPath(append(path, key))] = true
+	}
+
+	return value
+}
+
+// Registers secret values in logger, so they won't appear in log output.
+func (conf Configuration) registerSecrets(cfg *configstruct.ConfigStruct) {
+	for _, webhook := range cfg.Webhooks {
+		// Team and bot IDs are short and might appear in logs as part
+		// of other values, webhook URL can't be used without longrandom
+		// anyway.
+		ctx.RegisterSecret(webhook.Slack.LongRandom)
+		ctx.RegisterSecret(webhook.Slack.SigningSecret)
+		ctx.RegisterSecret(webhook.Slack.SharedSecret)
+	}
+
+	for _, bot := range cfg.WebAPI.Bots {
+		ctx.RegisterSecret(bot.Token)
+	}
+
+	for _, conn := range cfg.Matrix {
+		ctx.RegisterSecret(conn.Password)
+	}
+
+	for _, conn := range cfg.Telegram {
+		ctx.RegisterSecret(conn.BotID)
+		ctx.RegisterSecret(conn.Proxy.Password)
+	}
+}
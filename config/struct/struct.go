@@ -19,33 +19,307 @@
 // nolint:tagliatelle
 package configstruct
 
+import (
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
 // ConfigStruct is a config's root.
 type ConfigStruct struct {
 	Webhooks     map[string]ConfigWebhook  `yaml:"webhooks"`
 	Matrix       map[string]ConfigMatrix   `yaml:"matrix"`
 	Telegram     map[string]ConfigTelegram `yaml:"telegram"`
 	SlackHandler ConfigSlackHandler        `yaml:"slackhandler"`
+	WebAPI       ConfigWebAPI              `yaml:"webapi"`
+	Queue        ConfigQueue               `yaml:"queue"`
+	Log          ConfigLog                 `yaml:"log"`
+	// Webhook names by URL path keys, see IndexWebhooks().
+	webhooksIndex map[string]string
+}
+
+// FindWebhook returns name of webhook with passed URL path parts.
+func (cs *ConfigStruct) FindWebhook(team string, bot string, secret string) (string, bool) {
+	name, found := cs.webhooksIndex[team+"/"+bot+"/"+secret]
+
+	return name, found
+}
+
+// IndexWebhooks builds index used by FindWebhook(). Should be called
+// after configuration was loaded and validated.
+func (cs *ConfigStruct) IndexWebhooks() {
+	cs.webhooksIndex = make(map[string]string)
+
+	for name, webhook := range cs.Webhooks {
+		for _, key := range webhook.Slack.PathKeys() {
+			cs.webhooksIndex[key] = name
+		}
+	}
+}
+
+// ConfigLog is a logging configuration.
+type ConfigLog struct {
+	// Level is a minimal level of messages to log.
+	Level string `yaml:"level"`
+	// Format is a log format, "console" or "json".
+	Format string `yaml:"format"`
+	// Output is "stdout", "stderr" or path to log file.
+	Output string `yaml:"output"`
+	// MaxSize is a log file size in megabytes after which it will be
+	// rotated. Zero disables rotation.
+	MaxSize int `yaml:"max_size"`
+	// MaxFiles is a number of rotated log files to keep.
+	MaxFiles int `yaml:"max_files"`
+	// NoColor disables colors in console format.
+	NoColor bool `yaml:"no_color"`
+}
+
+// GetFormat returns log format.
+func (cl ConfigLog) GetFormat() string {
+	if cl.Format == "" {
+		return "console"
+	}
+
+	return cl.Format
+}
+
+// GetLevel returns minimal level of messages to log.
+func (cl ConfigLog) GetLevel() string {
+	if cl.Level == "" {
+		return "info"
+	}
+
+	return cl.Level
+}
+
+// GetMaxFiles returns number of rotated log files to keep.
+func (cl ConfigLog) GetMaxFiles() int {
+	if cl.MaxFiles <= 0 {
+		// nolint:gomnd
+		return 5
+	}
+
+	return cl.MaxFiles
+}
+
+// GetOutput returns log output.
+func (cl ConfigLog) GetOutput() string {
+	if cl.Output == "" {
+		return "stdout"
+	}
+
+	return cl.Output
+}
+
+// ConfigWebAPI is a Slack Web API emulation configuration.
+type ConfigWebAPI struct {
+	// Bots which are allowed to use Web API, by name.
+	Bots map[string]ConfigWebAPIBot `yaml:"bots"`
+	// MessagesFile is a path to file where IDs of sent messages are
+	// stored, so they can be updated or deleted after restart. IDs are
+	// kept in memory only if empty.
+	MessagesFile string `yaml:"messages_file"`
+	// MessagesRetention is a time after which message IDs are forgotten.
+	MessagesRetention time.Duration `yaml:"messages_retention"`
+}
+
+// GetMessagesRetention returns time after which message IDs are forgotten.
+func (cwa ConfigWebAPI) GetMessagesRetention() time.Duration {
+	if cwa.MessagesRetention <= 0 {
+		// nolint:gomnd
+		return 7 * 24 * time.Hour
+	}
+
+	return cwa.MessagesRetention
+}
+
+// ConfigWebAPIBot is a Slack Web API bot (token) configuration.
+type ConfigWebAPIBot struct {
+	// Channels maps Slack channel names (or IDs) to destinations. "*"
+	// matches any channel not listed explicitly.
+	Channels map[string]ConfigWebhookRemotes `yaml:"channels"`
+	// Token is a bot token, like "xoxb-...".
+	Token     string `yaml:"token"`
+	TokenFile string `yaml:"token_file"`
+}
+
+// GetChannel returns destinations for passed Slack channel. Leading "#"
+// and case are ignored.
+func (cwb ConfigWebAPIBot) GetChannel(channel string) (ConfigWebhookRemotes, bool) {
+	channel = NormalizeChannel(channel)
+
+	for name, remotes := range cwb.Channels {
+		if NormalizeChannel(name) == channel {
+			return remotes, true
+		}
+	}
+
+	remotes, found := cwb.Channels["*"]
+
+	return remotes, found
+}
+
+// NormalizeChannel returns channel name without leading "#" in lower case.
+func NormalizeChannel(channel string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(channel), "#"))
 }
 
 // Slack handler configuration.
 type ConfigSlackHandler struct {
 	Listener ConfigSlackHandlerListener `yaml:"listener"`
+	// AdminListener is an optional separate listener for administrative
+	// endpoints like metrics. If not set - they'll be served by Slack
+	// Webhooks API listener.
+	AdminListener ConfigSlackHandlerListener `yaml:"admin_listener"`
+	// PathPrefixes are URL path prefixes webhook URLs might start with,
+	// e.g. "/services". "/" means that URL starts with webhook's random
+	// parts.
+	PathPrefixes []string `yaml:"path_prefixes"`
+	// SignatureMaxAge is a maximum difference between signed request's
+	// timestamp and current time.
+	SignatureMaxAge time.Duration `yaml:"signature_max_age"`
+}
+
+// GetPathPrefixes returns URL path prefixes without trailing slashes.
+// Root prefix is returned as empty string.
+func (csh ConfigSlackHandler) GetPathPrefixes() []string {
+	prefixes := csh.PathPrefixes
+	if len(prefixes) == 0 {
+		prefixes = []string{"/services", "/"}
+	}
+
+	result := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		result = append(result, strings.TrimRight(prefix, "/"))
+	}
+
+	return result
+}
+
+// GetSignatureMaxAge returns maximum difference between signed request's
+// timestamp and current time.
+func (csh ConfigSlackHandler) GetSignatureMaxAge() time.Duration {
+	if csh.SignatureMaxAge <= 0 {
+		// nolint:gomnd
+		return 5 * time.Minute
+	}
+
+	return csh.SignatureMaxAge
 }
 
 type ConfigSlackHandlerListener struct {
 	Address string `yaml:"address"`
 }
 
+// ConfigQueue is a delivery queue configuration.
+type ConfigQueue struct {
+	// Directory where messages waiting for delivery will be stored.
+	// Messages are kept in memory if empty.
+	Directory      string        `yaml:"directory"`
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	// MaxPending is a maximum number of messages waiting for delivery to
+	// single destination. New messages are rejected if it is reached.
+	MaxPending int `yaml:"max_pending"`
+	// Workers is a number of delivery workers per destination.
+	Workers int `yaml:"workers"`
+}
+
+// GetInitialBackoff returns delay before second delivery attempt.
+func (cq ConfigQueue) GetInitialBackoff() time.Duration {
+	if cq.InitialBackoff <= 0 {
+		// nolint:gomnd
+		return 5 * time.Second
+	}
+
+	return cq.InitialBackoff
+}
+
+// GetMaxAttempts returns number of delivery attempts after which
+// message will be moved to dead letters.
+func (cq ConfigQueue) GetMaxAttempts() int {
+	if cq.MaxAttempts <= 0 {
+		// nolint:gomnd
+		return 10
+	}
+
+	return cq.MaxAttempts
+}
+
+// GetMaxPending returns maximum number of messages waiting for delivery
+// to single destination.
+func (cq ConfigQueue) GetMaxPending() int {
+	if cq.MaxPending <= 0 {
+		// nolint:gomnd
+		return 1000
+	}
+
+	return cq.MaxPending
+}
+
+// GetWorkers returns number of delivery workers per destination.
+func (cq ConfigQueue) GetWorkers() int {
+	if cq.Workers <= 0 {
+		return 1
+	}
+
+	return cq.Workers
+}
+
+// GetMaxBackoff returns maximum delay between delivery attempts.
+func (cq ConfigQueue) GetMaxBackoff() time.Duration {
+	if cq.MaxBackoff <= 0 {
+		// nolint:gomnd
+		return 10 * time.Minute
+	}
+
+	return cq.MaxBackoff
+}
+
 // Webhook configuration.
 type ConfigWebhook struct {
-	Slack  ConfigWebhookSlack  `yaml:"slack"`
-	Remote ConfigWebhookRemote `yaml:"remote"`
+	Slack  ConfigWebhookSlack   `yaml:"slack"`
+	Remote ConfigWebhookRemotes `yaml:"remote"`
 }
 
 type ConfigWebhookSlack struct {
-	Random1    string `yaml:"random1"`
-	Random2    string `yaml:"random2"`
-	LongRandom string `yaml:"longrandom"`
+	Random1        string `yaml:"random1"`
+	Random1File    string `yaml:"random1_file"`
+	Random2        string `yaml:"random2"`
+	Random2File    string `yaml:"random2_file"`
+	LongRandom     string `yaml:"longrandom"`
+	LongRandomFile string `yaml:"longrandom_file"`
+	// SigningSecret is used to verify X-Slack-Signature header. If
+	// set (or if SharedSecret is set) - unsigned requests are rejected.
+	SigningSecret     string `yaml:"signing_secret"`
+	SigningSecretFile string `yaml:"signing_secret_file"`
+	// SharedSecret should be passed in X-OpenSAPS-Secret or
+	// Authorization header by applications that can't sign requests.
+	SharedSecret     string `yaml:"shared_secret"`
+	SharedSecretFile string `yaml:"shared_secret_file"`
+}
+
+// PathKeys returns "TEAM/BOT/SECRET" keys of URL paths webhook can be
+// accessed with. "T" and "B" prefixes of first two parts are optional, so
+// they can be omitted in configuration.
+func (cws ConfigWebhookSlack) PathKeys() []string {
+	keys := make([]string, 0)
+
+	for _, team := range []string{cws.Random1, "T" + cws.Random1} {
+		for _, bot := range []string{cws.Random2, "B" + cws.Random2} {
+			keys = append(keys, team+"/"+bot+"/"+cws.LongRandom)
+		}
+	}
+
+	return keys
+}
+
+// IsAuthRequired returns true if requests to webhook should be signed or
+// should contain shared secret.
+func (cws ConfigWebhookSlack) IsAuthRequired() bool {
+	return cws.SigningSecret != "" || cws.SharedSecret != ""
 }
 
 type ConfigWebhookRemote struct {
@@ -53,26 +327,139 @@ type ConfigWebhookRemote struct {
 	PushTo string `yaml:"push_to"`
 }
 
+// ConfigWebhookRemotes is a list of destinations received data should be
+// pushed to. For compatibility with older configuration files it can
+// also be defined as single destination.
+type ConfigWebhookRemotes []ConfigWebhookRemote
+
+// UnmarshalYAML accepts both list of destinations and single destination.
+func (cwr *ConfigWebhookRemotes) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.SequenceNode {
+		var remotes []ConfigWebhookRemote
+		if err := value.Decode(&remotes); err != nil {
+			return err
+		}
+
+		*cwr = remotes
+
+		return nil
+	}
+
+	// nolint:exhaustruct
+	remote := ConfigWebhookRemote{}
+	if err := value.Decode(&remote); err != nil {
+		return err
+	}
+
+	*cwr = ConfigWebhookRemotes{remote}
+
+	return nil
+}
+
 // Matrix pusher configuration.
 type ConfigMatrix struct {
-	APIRoot  string `yaml:"api_root"`
-	User     string `yaml:"user"`
-	Password string `yaml:"password"`
-	Room     string `yaml:"room"`
+	APIRoot      string `yaml:"api_root"`
+	User         string `yaml:"user"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+	Room         string `yaml:"room"`
+	// RateLimit is a number of messages per second connection is allowed
+	// to send on average.
+	RateLimit float64 `yaml:"rate_limit"`
+	// RateBurst is a number of messages connection is allowed to send at
+	// once before rate limit will be applied.
+	RateBurst int `yaml:"rate_burst"`
+}
+
+// GetRateBurst returns number of messages connection is allowed to send
+// at once.
+func (cm ConfigMatrix) GetRateBurst() int {
+	if cm.RateBurst <= 0 {
+		// nolint:gomnd
+		return 10
+	}
+
+	return cm.RateBurst
+}
+
+// GetRateLimit returns number of messages per second connection is
+// allowed to send.
+func (cm ConfigMatrix) GetRateLimit() float64 {
+	if cm.RateLimit <= 0 {
+		return 1
+	}
+
+	return cm.RateLimit
 }
 
 // ConfigTelegram is a telegram pusher configuration.
 type ConfigTelegram struct {
-	BotID  string      `yaml:"bot_id"`
-	ChatID string      `yaml:"chat_id"`
-	Proxy  ConfigProxy `yaml:"proxy"`
+	BotID     string      `yaml:"bot_id"`
+	BotIDFile string      `yaml:"bot_id_file"`
+	ChatID    string      `yaml:"chat_id"`
+	Proxy     ConfigProxy `yaml:"proxy"`
+	// RateLimit is a number of messages per second connection is allowed
+	// to send to chat on average.
+	RateLimit float64 `yaml:"rate_limit"`
+	// RateBurst is a number of messages connection is allowed to send at
+	// once before rate limit will be applied.
+	RateBurst int `yaml:"rate_burst"`
+	// MaxParts is a maximum number of messages long message will be
+	// split into.
+	MaxParts int `yaml:"max_parts"`
+	// TruncationMarker is appended to message if it was truncated.
+	TruncationMarker string `yaml:"truncation_marker"`
+}
+
+// GetMaxParts returns maximum number of messages long message will be
+// split into.
+func (ct ConfigTelegram) GetMaxParts() int {
+	if ct.MaxParts <= 0 {
+		// nolint:gomnd
+		return 5
+	}
+
+	return ct.MaxParts
+}
+
+// GetTruncationMarker returns text which is appended to truncated message.
+func (ct ConfigTelegram) GetTruncationMarker() string {
+	if ct.TruncationMarker == "" {
+		return "… (message truncated)"
+	}
+
+	return ct.TruncationMarker
+}
+
+// GetRateBurst returns number of messages connection is allowed to send
+// at once.
+func (ct ConfigTelegram) GetRateBurst() int {
+	if ct.RateBurst <= 0 {
+		// nolint:gomnd
+		return 3
+	}
+
+	return ct.RateBurst
+}
+
+// GetRateLimit returns number of messages per second connection is
+// allowed to send. Default is Telegram's limit for groups, 20 messages
+// per minute.
+func (ct ConfigTelegram) GetRateLimit() float64 {
+	if ct.RateLimit <= 0 {
+		// nolint:gomnd
+		return 20.0 / 60
+	}
+
+	return ct.RateLimit
 }
 
 // ConfigProxy represents proxy server configuration.
 type ConfigProxy struct {
-	ProxyType string `yaml:"proxy_type"`
-	Address   string `yaml:"address"`
-	User      string `yaml:"user"`
-	Password  string `yaml:"password"`
-	Enabled   bool   `yaml:"enabled"`
+	ProxyType    string `yaml:"proxy_type"`
+	Address      string `yaml:"address"`
+	User         string `yaml:"user"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+	Enabled      bool   `yaml:"enabled"`
 }
@@ -0,0 +1,500 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	configurationinterface "go.dev.pztrn.name/opensaps/config/interface"
+	configstruct "go.dev.pztrn.name/opensaps/config/struct"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// Slack webhook URL parts should be usable as URL path elements.
+	slackURLPartRegexp = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+	// Telegram bot token looks like "123456789:AAHdqTcvCH1vGWJxfSeofSAs0K5PALDsaw".
+	telegramTokenRegexp = regexp.MustCompile(`^[0-9]+:[A-Za-z0-9_-]+$`)
+	// Telegram chat ID is a number (negative for groups) or channel's username.
+	telegramChatIDRegexp = regexp.MustCompile(`^(-?[0-9]+|@[A-Za-z0-9_]+)$`)
+)
+
+// Validator checks configuration for mistakes and remembers where in
+// configuration file they were made.
+type validator struct {
+	// Root node of parsed configuration file. Used to find line numbers.
+	root   *yaml.Node
+	errors configurationinterface.ValidationErrors
+	// Paths of secret values which failed to resolve. Other problems with
+	// them aren't reported, as they are caused by it.
+	unresolved map[string]bool
+}
+
+// Adds problem with value located at passed path. Path elements are
+// mapping keys and sequence indexes.
+func (v *validator) addError(path []string, format string, args ...interface{}) {
+	if v.unresolved[v.formatPath(path)] {
+		return
+	}
+
+	v.errors = append(v.errors, configurationinterface.ValidationError{
+		Path:    v.formatPath(path),
+		Message: fmt.Sprintf(format, args...),
+		Line:    v.findLine(path),
+	})
+}
+
+// Returns line number for value located at passed path. If value isn't
+// present in configuration file - line of closest parent is returned.
+func (v *validator) findLine(path []string) int {
+	if v.root == nil {
+		return 0
+	}
+
+	node := v.root
+	// Document node wraps actual content.
+	if node.Kind == yaml.DocumentNode && len(node.Content) != 0 {
+		node = node.Content[0]
+	}
+
+	for _, element := range path {
+		next := v.findChild(node, element)
+		if next == nil {
+			break
+		}
+
+		node = next
+	}
+
+	return node.Line
+}
+
+func (v *validator) findChild(node *yaml.Node, element string) *yaml.Node {
+	switch node.Kind {
+	case yaml.MappingNode:
+		// Mapping node's content is a list of keys and values.
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == element {
+				// Empty values will point to line where key is.
+				if node.Content[i+1].Line == 0 {
+					return node.Content[i]
+				}
+
+				return node.Content[i+1]
+			}
+		}
+	case yaml.SequenceNode:
+		idx, err := strconv.Atoi(element)
+		if err == nil && idx >= 0 && idx < len(node.Content) {
+			return node.Content[idx]
+		}
+	}
+
+	return nil
+}
+
+// Formats path like "webhooks.gitea.remote[0].pusher". Numeric path
+// elements are considered as sequence indexes.
+func (v *validator) formatPath(path []string) string {
+	var formatted string
+
+	for _, element := range path {
+		if _, err := strconv.Atoi(element); err == nil {
+			formatted += "[" + element + "]"
+
+			continue
+		}
+
+		if formatted != "" {
+			formatted += "."
+		}
+
+		formatted += element
+	}
+
+	return formatted
+}
+
+// Returns map keys sorted, so problems will be reported in same order
+// every time.
+func (v *validator) sortedKeys(keys []string) []string {
+	sort.Strings(keys)
+
+	return keys
+}
+
+func (v *validator) validate(cfg *configstruct.ConfigStruct) configurationinterface.ValidationErrors {
+	v.validateListener([]string{"slackhandler", "listener", "address"}, cfg.SlackHandler.Listener.Address)
+	v.validateAdminListener(cfg.SlackHandler)
+
+	for idx, prefix := range cfg.SlackHandler.PathPrefixes {
+		if !strings.HasPrefix(prefix, "/") {
+			v.addError([]string{"slackhandler", "path_prefixes", strconv.Itoa(idx)}, "'%s' should start with '/'", prefix)
+		}
+	}
+
+	if cfg.SlackHandler.SignatureMaxAge < 0 {
+		v.addError([]string{"slackhandler", "signature_max_age"}, "should not be negative")
+	}
+
+	v.validateQueue(cfg.Queue)
+	v.validateLog(cfg.Log)
+	v.validateWebhooks(cfg)
+	v.validateWebAPI(cfg)
+	v.validateMatrix(cfg.Matrix)
+	v.validateTelegram(cfg.Telegram)
+
+	sort.SliceStable(v.errors, func(i, j int) bool { return v.errors[i].Line < v.errors[j].Line })
+
+	return v.errors
+}
+
+func (v *validator) validateListener(path []string, address string) {
+	if address == "" {
+		v.addError(path, "listener address should be set")
+
+		return
+	}
+
+	if !v.isValidAddress(address) {
+		v.addError(path, "'%s' isn't valid listener address, should be in 'host:port' form", address)
+	}
+}
+
+func (v *validator) validateAdminListener(cfg configstruct.ConfigSlackHandler) {
+	path := []string{"slackhandler", "admin_listener", "address"}
+
+	if cfg.AdminListener.Address == "" {
+		return
+	}
+
+	if !v.isValidAddress(cfg.AdminListener.Address) {
+		v.addError(path, "'%s' isn't valid listener address, should be in 'host:port' form", cfg.AdminListener.Address)
+
+		return
+	}
+
+	if cfg.AdminListener.Address == cfg.Listener.Address {
+		v.addError(path, "should differ from slackhandler.listener.address")
+	}
+}
+
+func (v *validator) isValidAddress(address string) bool {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+
+	portNumber, err := strconv.Atoi(port)
+
+	// nolint:gomnd
+	return err == nil && portNumber >= 0 && portNumber <= 65535
+}
+
+func (v *validator) validateLog(cfg configstruct.ConfigLog) {
+	switch strings.ToLower(cfg.GetLevel()) {
+	case "trace", "debug", "info", "warn", "error", "fatal", "panic", "disabled":
+	default:
+		v.addError([]string{"log", "level"}, "unknown log level '%s', should be 'debug', 'info', 'warn' or 'error'", cfg.Level)
+	}
+
+	if cfg.GetFormat() != "console" && cfg.GetFormat() != "json" {
+		v.addError([]string{"log", "format"}, "unknown log format '%s', should be 'console' or 'json'", cfg.Format)
+	}
+
+	if cfg.MaxSize < 0 {
+		v.addError([]string{"log", "max_size"}, "should not be negative")
+	}
+
+	if cfg.MaxFiles < 0 {
+		v.addError([]string{"log", "max_files"}, "should not be negative")
+	}
+}
+
+func (v *validator) validateQueue(cfg configstruct.ConfigQueue) {
+	if cfg.MaxAttempts < 0 {
+		v.addError([]string{"queue", "max_attempts"}, "should not be negative")
+	}
+
+	if cfg.InitialBackoff < 0 {
+		v.addError([]string{"queue", "initial_backoff"}, "should not be negative")
+	}
+
+	if cfg.MaxBackoff < 0 {
+		v.addError([]string{"queue", "max_backoff"}, "should not be negative")
+	}
+
+	if cfg.MaxPending < 0 {
+		v.addError([]string{"queue", "max_pending"}, "should not be negative")
+	}
+
+	if cfg.Workers < 0 {
+		v.addError([]string{"queue", "workers"}, "should not be negative")
+	}
+
+	if cfg.GetInitialBackoff() > cfg.GetMaxBackoff() {
+		v.addError([]string{"queue", "initial_backoff"}, "initial backoff (%s) is greater than maximum backoff (%s)",
+			cfg.GetInitialBackoff(), cfg.GetMaxBackoff())
+	}
+}
+
+func (v *validator) validateWebhooks(cfg *configstruct.ConfigStruct) {
+	names := make([]string, 0, len(cfg.Webhooks))
+	for name := range cfg.Webhooks {
+		names = append(names, name)
+	}
+
+	// Webhook's URL path keys and name of webhook which uses them.
+	urls := make(map[string]string)
+
+	for _, name := range v.sortedKeys(names) {
+		webhook := cfg.Webhooks[name]
+		path := []string{"webhooks", name}
+
+		slackParts := map[string]string{
+			"random1":    webhook.Slack.Random1,
+			"random2":    webhook.Slack.Random2,
+			"longrandom": webhook.Slack.LongRandom,
+		}
+
+		for _, part := range []string{"random1", "random2", "longrandom"} {
+			value := slackParts[part]
+
+			switch {
+			case value == "":
+				v.addError(append(path, "slack", part), "should be set")
+			case !slackURLPartRegexp.MatchString(value):
+				v.addError(append(path, "slack", part), "'%s' should contain only latin letters and digits", value)
+			}
+		}
+
+		// "T" and "B" prefixes are optional, so different values might
+		// give same URL.
+		for _, key := range webhook.Slack.PathKeys() {
+			if otherName, found := urls[key]; found && otherName != name {
+				v.addError(append(path, "slack"), "random1, random2 and longrandom gives same URL as for webhook '%s'",
+					otherName)
+
+				break
+			}
+
+			urls[key] = name
+		}
+
+		if len(webhook.Remote) == 0 {
+			v.addError(append(path, "remote"), "at least one destination should be defined")
+		}
+
+		for idx, remote := range webhook.Remote {
+			v.validateRemote(cfg, append(path, "remote", strconv.Itoa(idx)), remote)
+		}
+	}
+}
+
+func (v *validator) validateWebAPI(cfg *configstruct.ConfigStruct) {
+	names := make([]string, 0, len(cfg.WebAPI.Bots))
+	for name := range cfg.WebAPI.Bots {
+		names = append(names, name)
+	}
+
+	if cfg.WebAPI.MessagesRetention < 0 {
+		v.addError([]string{"webapi", "messages_retention"}, "should not be negative")
+	}
+
+	// Tokens and names of bots which uses them.
+	tokens := make(map[string]string)
+
+	for _, name := range v.sortedKeys(names) {
+		bot := cfg.WebAPI.Bots[name]
+		path := []string{"webapi", "bots", name}
+
+		if bot.Token == "" {
+			v.addError(append(path, "token"), "should be set")
+		} else if otherName, found := tokens[bot.Token]; found {
+			// Token is a secret, so it shouldn't be printed.
+			v.addError(append(path, "token"), "same token is used by bot '%s'", otherName)
+		} else {
+			tokens[bot.Token] = name
+		}
+
+		if len(bot.Channels) == 0 {
+			v.addError(append(path, "channels"), "at least one channel should be defined")
+		}
+
+		channels := make([]string, 0, len(bot.Channels))
+		for channel := range bot.Channels {
+			channels = append(channels, channel)
+		}
+
+		// Channel names are case-insensitive.
+		normalized := make(map[string]string)
+
+		for _, channel := range v.sortedKeys(channels) {
+			channelPath := append(path, "channels", channel)
+
+			if otherChannel, found := normalized[configstruct.NormalizeChannel(channel)]; found {
+				v.addError(channelPath, "same channel as '%s'", otherChannel)
+			} else {
+				normalized[configstruct.NormalizeChannel(channel)] = channel
+			}
+
+			if len(bot.Channels[channel]) == 0 {
+				v.addError(channelPath, "at least one destination should be defined")
+			}
+
+			for idx, remote := range bot.Channels[channel] {
+				v.validateRemote(cfg, append(channelPath, strconv.Itoa(idx)), remote)
+			}
+		}
+	}
+}
+
+func (v *validator) validateRemote(cfg *configstruct.ConfigStruct, path []string, remote configstruct.ConfigWebhookRemote) {
+	var found bool
+
+	switch remote.Pusher {
+	case "matrix":
+		_, found = cfg.Matrix[remote.PushTo]
+	case "telegram":
+		_, found = cfg.Telegram[remote.PushTo]
+	case "":
+		v.addError(append(path, "pusher"), "should be set")
+
+		return
+	default:
+		v.addError(append(path, "pusher"), "unknown pusher '%s', should be 'matrix' or 'telegram'", remote.Pusher)
+
+		return
+	}
+
+	if !found {
+		v.addError(append(path, "push_to"), "connection '%s' isn't defined in '%s' section", remote.PushTo, remote.Pusher)
+	}
+}
+
+func (v *validator) validateMatrix(connections map[string]configstruct.ConfigMatrix) {
+	names := make([]string, 0, len(connections))
+	for name := range connections {
+		names = append(names, name)
+	}
+
+	for _, name := range v.sortedKeys(names) {
+		conn := connections[name]
+		path := []string{"matrix", name}
+
+		apiRoot, err := url.Parse(conn.APIRoot)
+
+		switch {
+		case conn.APIRoot == "":
+			v.addError(append(path, "api_root"), "should be set")
+		case err != nil || (apiRoot.Scheme != "http" && apiRoot.Scheme != "https") || apiRoot.Host == "":
+			v.addError(append(path, "api_root"), "'%s' isn't valid HTTP(S) URL", conn.APIRoot)
+		}
+
+		if conn.User == "" {
+			v.addError(append(path, "user"), "should be set")
+		}
+
+		if conn.Password == "" {
+			v.addError(append(path, "password"), "should be set")
+		}
+
+		switch {
+		case conn.Room == "":
+			v.addError(append(path, "room"), "should be set")
+		case !strings.HasPrefix(conn.Room, "!") && !strings.HasPrefix(conn.Room, "#"),
+			!strings.Contains(conn.Room, ":"):
+			v.addError(append(path, "room"), "'%s' isn't valid room ID or alias, should look like '!roomid:server.tld'",
+				conn.Room)
+		}
+
+		if conn.RateLimit < 0 {
+			v.addError(append(path, "rate_limit"), "should not be negative")
+		}
+
+		if conn.RateBurst < 0 {
+			v.addError(append(path, "rate_burst"), "should not be negative")
+		}
+	}
+}
+
+func (v *validator) validateTelegram(connections map[string]configstruct.ConfigTelegram) {
+	names := make([]string, 0, len(connections))
+	for name := range connections {
+		names = append(names, name)
+	}
+
+	for _, name := range v.sortedKeys(names) {
+		conn := connections[name]
+		path := []string{"telegram", name}
+
+		switch {
+		case conn.BotID == "":
+			v.addError(append(path, "bot_id"), "should be set")
+		case !telegramTokenRegexp.MatchString(conn.BotID):
+			// Token is a secret, so it shouldn't be printed.
+			v.addError(append(path, "bot_id"), "isn't valid bot token, should look like '123456789:AAHdqTcvCH1vGWJxfSeof'")
+		}
+
+		switch {
+		case conn.ChatID == "":
+			v.addError(append(path, "chat_id"), "should be set")
+		case !telegramChatIDRegexp.MatchString(conn.ChatID):
+			v.addError(append(path, "chat_id"), "'%s' isn't valid chat ID, should be a number or '@channelname'", conn.ChatID)
+		}
+
+		if conn.RateLimit < 0 {
+			v.addError(append(path, "rate_limit"), "should not be negative")
+		}
+
+		if conn.RateBurst < 0 {
+			v.addError(append(path, "rate_burst"), "should not be negative")
+		}
+
+		if conn.MaxParts < 0 {
+			v.addError(append(path, "max_parts"), "should not be negative")
+		}
+
+		// Marker should leave some space for message itself.
+		// nolint:gomnd
+		if utf8.RuneCountInString(conn.TruncationMarker) > 256 {
+			v.addError(append(path, "truncation_marker"), "should not be longer than 256 characters")
+		}
+
+		if !conn.Proxy.Enabled {
+			continue
+		}
+
+		if conn.Proxy.ProxyType != "" && conn.Proxy.ProxyType != "http" {
+			v.addError(append(path, "proxy", "proxy_type"), "unsupported proxy type '%s', only 'http' is supported",
+				conn.Proxy.ProxyType)
+		}
+
+		if !v.isValidAddress(conn.Proxy.Address) {
+			v.addError(append(path, "proxy", "address"), "'%s' isn't valid proxy address, should be in 'host:port' form",
+				conn.Proxy.Address)
+		}
+	}
+}
@@ -26,8 +26,11 @@ import (
 	"github.com/rs/zerolog"
 	"go.dev.pztrn.name/flagger"
 	configurationinterface "go.dev.pztrn.name/opensaps/config/interface"
+	messagemapinterface "go.dev.pztrn.name/opensaps/messagemap/interface"
+	metricsinterface "go.dev.pztrn.name/opensaps/metrics/interface"
 	parserinterface "go.dev.pztrn.name/opensaps/parsers/interface"
 	pusherinterface "go.dev.pztrn.name/opensaps/pushers/interface"
+	queueinterface "go.dev.pztrn.name/opensaps/queue/interface"
 	slackapiserverinterface "go.dev.pztrn.name/opensaps/slack/apiserverinterface"
 	slackmessage "go.dev.pztrn.name/opensaps/slack/message"
 )
@@ -36,48 +39,63 @@ type Context struct {
 	Config         configurationinterface.ConfigurationInterface
 	SlackAPIServer slackapiserverinterface.SlackAPIServerInterface
 	Flagger        *flagger.Flagger
+	MessageMap     messagemapinterface.MessageMapInterface
+	Metrics        metricsinterface.MetricsInterface
 	Parsers        map[string]parserinterface.ParserInterface
 	Pushers        map[string]pusherinterface.PusherInterface
+	Queue          queueinterface.QueueInterface
 	Log            zerolog.Logger
+	// Log output, can be changed on the fly.
+	logOutput *logOutput
+	// Masks secrets in log output.
+	redactor *Redactor
 }
 
 func (c *Context) Initialize() {
 	c.Parsers = make(map[string]parserinterface.ParserInterface)
 	c.Pushers = make(map[string]pusherinterface.PusherInterface)
 
+	// Logs are written to stdout until logging configuration will be
+	// applied with ConfigureLogger().
 	// nolint:exhaustruct
-	output := zerolog.ConsoleWriter{Out: os.Stdout, NoColor: false, TimeFormat: time.RFC3339}
-	output.FormatLevel = func(lvlRaw interface{}) string {
-		var formattedLvl string
-
-		if lvl, ok := lvlRaw.(string); ok {
-			lvl = strings.ToUpper(lvl)
-			switch lvl {
-			case "DEBUG":
-				formattedLvl = fmt.Sprintf("\x1b[30m%-5s\x1b[0m", lvl)
-			case "ERROR":
-				formattedLvl = fmt.Sprintf("\x1b[31m%-5s\x1b[0m", lvl)
-			case "FATAL":
-				formattedLvl = fmt.Sprintf("\x1b[35m%-5s\x1b[0m", lvl)
-			case "INFO":
-				formattedLvl = fmt.Sprintf("\x1b[32m%-5s\x1b[0m", lvl)
-			case "PANIC":
-				formattedLvl = fmt.Sprintf("\x1b[36m%-5s\x1b[0m", lvl)
-			case "WARN":
-				formattedLvl = fmt.Sprintf("\x1b[33m%-5s\x1b[0m", lvl)
-			default:
-				formattedLvl = lvl
-			}
-		}
-
-		return fmt.Sprintf("| %s |", formattedLvl)
-	}
+	c.logOutput = &logOutput{writer: c.newConsoleWriter(os.Stdout, c.isTerminal(os.Stdout))}
+	c.redactor = newRedactor(c.logOutput)
 
-	c.Log = zerolog.New(output).With().Timestamp().Logger()
+	c.Log = zerolog.New(c.redactor).With().Timestamp().Logger()
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
 
 	flaggerLogger := &FlaggerLogger{log: c.Log}
 	c.Flagger = flagger.New("opensaps", flagger.LoggerInterface(flaggerLogger))
 	c.Flagger.Initialize()
+
+	c.addLoggerFlags()
+}
+
+func (c *Context) addLoggerFlags() {
+	flags := []flagger.Flag{
+		{
+			Name:         "log-level",
+			Description:  "Log level (debug, info, warn, error). Overrides value from configuration file.",
+			Type:         "string",
+			DefaultValue: "",
+		},
+		{
+			Name:         "log-format",
+			Description:  "Log format (console, json). Overrides value from configuration file.",
+			Type:         "string",
+			DefaultValue: "",
+		},
+		{
+			Name:         "log-output",
+			Description:  "Log output (stdout, stderr or path to file). Overrides value from configuration file.",
+			Type:         "string",
+			DefaultValue: "",
+		},
+	}
+
+	for idx := range flags {
+		_ = c.Flagger.AddFlag(&flags[idx])
+	}
 }
 
 // Registers configuration interface.
@@ -86,6 +104,18 @@ func (c *Context) RegisterConfigurationInterface(ci configurationinterface.Confi
 	c.Config.Initialize()
 }
 
+// Registers Web API messages map interface.
+func (c *Context) RegisterMessageMapInterface(mmi messagemapinterface.MessageMapInterface) {
+	c.MessageMap = mmi
+	c.MessageMap.Initialize()
+}
+
+// Registers metrics interface.
+func (c *Context) RegisterMetricsInterface(mi metricsinterface.MetricsInterface) {
+	c.Metrics = mi
+	c.Metrics.Initialize()
+}
+
 // Registers parser interface.
 func (c *Context) RegisterParserInterface(name string, iface parserinterface.ParserInterface) {
 	c.Parsers[name] = iface
@@ -98,6 +128,24 @@ func (c *Context) RegisterPusherInterface(name string, iface pusherinterface.Pus
 	c.Pushers[name].Initialize()
 }
 
+// Registers delivery queue interface.
+func (c *Context) RegisterQueueInterface(qi queueinterface.QueueInterface) {
+	c.Queue = qi
+	c.Queue.Initialize()
+}
+
+// Redact masks known secrets in passed string. Should be used for data
+// that leaves OpenSAPS other than via logs, like error messages.
+func (c *Context) Redact(data string) string {
+	return c.redactor.Redact(data)
+}
+
+// Registers value which should never appear in log output, like
+// passwords and tokens.
+func (c *Context) RegisterSecret(value string) {
+	c.redactor.AddSecret(value)
+}
+
 // Registers Slack API HTTP server control structure.
 // Russians will have pretty good luff on variable name.
 func (c *Context) RegisterSlackAPIServerInterface(sasi slackapiserverinterface.SlackAPIServerInterface) {
@@ -105,7 +153,7 @@ func (c *Context) RegisterSlackAPIServerInterface(sasi slackapiserverinterface.S
 	c.SlackAPIServer.Initialize()
 }
 
-func (c *Context) SendToParser(name string, message slackmessage.SlackMessage) map[string]interface{} {
+func (c *Context) SendToParser(name string, message slackmessage.SlackMessage) parserinterface.ParsedMessage {
 	parser, found := c.Parsers[strings.ToLower(name)]
 	if !found {
 		c.Log.Error().Msgf("Parser '%s' not found, will use default one!", name)
@@ -116,20 +164,103 @@ func (c *Context) SendToParser(name string, message slackmessage.SlackMessage) m
 	return parser.ParseMessage(message)
 }
 
-func (c *Context) SendToPusher(protocol string, connection string, data slackmessage.SlackMessage) {
+// DeleteFromPusher deletes message previously sent with SendToPusher.
+func (c *Context) DeleteFromPusher(protocol string, connection string, messageID string, deliveryID string) error {
+	pusher, err := c.getPusher(protocol)
+	if err != nil {
+		c.Metrics.MessagePushed(protocol, connection, metricsinterface.PushActionDelete, 0, err)
+
+		return err
+	}
+
+	start := time.Now()
+	err = pusher.Delete(connection, messageID, deliveryID)
+	c.Metrics.MessagePushed(protocol, connection, metricsinterface.PushActionDelete, time.Since(start), err)
+
+	return err
+}
+
+// SendToPusher sends message and returns ID of message created in remote
+// service.
+func (c *Context) SendToPusher(protocol string, connection string, data slackmessage.SlackMessage,
+	deliveryID string, sentMessageID string) (string, error) {
+	pusher, err := c.getPusher(protocol)
+	if err != nil {
+		c.Metrics.MessagePushed(protocol, connection, metricsinterface.PushActionPost, 0, err)
+
+		return "", err
+	}
+
+	start := time.Now()
+	messageID, err := pusher.Push(connection, data, deliveryID, sentMessageID)
+	c.Metrics.MessagePushed(protocol, connection, metricsinterface.PushActionPost, time.Since(start), err)
+
+	return messageID, err
+}
+
+// UpdateInPusher replaces content of message previously sent with
+// SendToPusher.
+func (c *Context) UpdateInPusher(protocol string, connection string, messageID string,
+	data slackmessage.SlackMessage, deliveryID string) error {
+	pusher, err := c.getPusher(protocol)
+	if err != nil {
+		c.Metrics.MessagePushed(protocol, connection, metricsinterface.PushActionUpdate, 0, err)
+
+		return err
+	}
+
+	start := time.Now()
+	err = pusher.Update(connection, messageID, data, deliveryID)
+	c.Metrics.MessagePushed(protocol, connection, metricsinterface.PushActionUpdate, time.Since(start), err)
+
+	return err
+}
+
+func (c *Context) getPusher(protocol string) (pusherinterface.PusherInterface, error) {
 	pusher, ok := c.Pushers[protocol]
 	if !ok {
 		c.Log.Error().Msgf("Pusher not found (or initialized) for protocol '%s'!", protocol)
+
+		return nil, fmt.Errorf("%w: %s", pusherinterface.ErrPusherNotFound, protocol)
 	}
 
-	pusher.Push(connection, data)
+	return pusher, nil
+}
+
+// Reloads configuration and applies it to every subsystem. If new
+// configuration is invalid, current one will be kept.
+func (c *Context) Reload() {
+	c.Log.Info().Msg("Reloading configuration...")
+
+	if err := c.Config.ReloadConfigurationFromFile(); err != nil {
+		c.Log.Error().Err(err).Msg("Failed to reload configuration, will continue to use current one")
+
+		return
+	}
+
+	c.ConfigureLogger()
+
+	for _, pusher := range c.Pushers {
+		pusher.Reload()
+	}
+
+	c.Queue.Reload()
+	c.MessageMap.Reload()
+	c.SlackAPIServer.Reload()
+
+	c.Log.Info().Msg("Configuration reloaded")
 }
 
 // Shutdown everything.
 func (c *Context) Shutdown() {
 	c.SlackAPIServer.Shutdown()
+	c.Queue.Shutdown()
 
 	for _, pusher := range c.Pushers {
 		pusher.Shutdown()
 	}
+
+	// Delivery queue stores IDs of delivered messages, so map should be
+	// saved after it was stopped.
+	c.MessageMap.Shutdown()
 }
@@ -0,0 +1,284 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package context
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	configstruct "go.dev.pztrn.name/opensaps/config/struct"
+)
+
+// Log output formats.
+const (
+	logFormatConsole = "console"
+	logFormatJSON    = "json"
+)
+
+// Special log outputs. Everything else is considered as file path.
+const (
+	logOutputStdout = "stdout"
+	logOutputStderr = "stderr"
+)
+
+// LogOutput is a writer which allows to change log output on the fly
+// while logger is used by other goroutines.
+type logOutput struct {
+	writer io.Writer
+	// Will be closed when output will be changed.
+	closer io.Closer
+	mutex  sync.Mutex
+}
+
+func (lo *logOutput) set(writer io.Writer, closer io.Closer) {
+	lo.mutex.Lock()
+	defer lo.mutex.Unlock()
+
+	if lo.closer != nil {
+		lo.closer.Close()
+	}
+
+	lo.writer = writer
+	lo.closer = closer
+}
+
+func (lo *logOutput) Write(data []byte) (int, error) {
+	lo.mutex.Lock()
+	defer lo.mutex.Unlock()
+
+	return lo.writer.Write(data)
+}
+
+// ConfigureLogger applies logging configuration. Values passed with
+// command line flags take precedence over configuration file. Can be
+// called before configuration file is loaded, in that case only flags
+// will be used.
+func (c *Context) ConfigureLogger() {
+	// nolint:exhaustruct
+	logCfg := configstruct.ConfigLog{}
+
+	if c.Config != nil {
+		if cfg := c.Config.GetConfig(); cfg != nil {
+			logCfg = cfg.Log
+		}
+	}
+
+	flags := map[string]*string{
+		"log-level":  &logCfg.Level,
+		"log-format": &logCfg.Format,
+		"log-output": &logCfg.Output,
+	}
+
+	for name, value := range flags {
+		if flagValue, err := c.Flagger.GetStringValue(name); err == nil && flagValue != "" {
+			*value = flagValue
+		}
+	}
+
+	level, err := zerolog.ParseLevel(strings.ToLower(logCfg.GetLevel()))
+	if err != nil {
+		c.Log.Error().Err(err).Str("level", logCfg.GetLevel()).Msg("Invalid log level, will use 'info'")
+
+		level = zerolog.InfoLevel
+	}
+
+	writer, closer, err := c.openLogOutput(logCfg)
+	if err != nil {
+		c.Log.Error().Err(err).Str("output", logCfg.GetOutput()).Msg("Failed to open log output, will continue to use current one")
+	} else {
+		c.logOutput.set(writer, closer)
+	}
+
+	zerolog.SetGlobalLevel(level)
+}
+
+// Opens log destination and wraps it with formatter if needed.
+func (c *Context) openLogOutput(logCfg configstruct.ConfigLog) (io.Writer, io.Closer, error) {
+	var (
+		destination io.Writer
+		closer      io.Closer
+		colorful    bool
+	)
+
+	switch logCfg.GetOutput() {
+	case logOutputStdout:
+		destination = os.Stdout
+		colorful = c.isTerminal(os.Stdout)
+	case logOutputStderr:
+		destination = os.Stderr
+		colorful = c.isTerminal(os.Stderr)
+	default:
+		file, err := newRotatingFile(logCfg.GetOutput(), int64(logCfg.MaxSize)*1024*1024, logCfg.GetMaxFiles())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		destination = file
+		closer = file
+	}
+
+	switch logCfg.GetFormat() {
+	case logFormatConsole:
+		return c.newConsoleWriter(destination, colorful && !logCfg.NoColor), closer, nil
+	case logFormatJSON:
+		return destination, closer, nil
+	}
+
+	if closer != nil {
+		closer.Close()
+	}
+
+	// nolint:goerr113
+	return nil, nil, fmt.Errorf("unknown log format '%s'", logCfg.GetFormat())
+}
+
+// Checks if passed file is a terminal, so it makes sense to use colors.
+func (c *Context) isTerminal(file *os.File) bool {
+	stat, err := file.Stat()
+	if err != nil {
+		return false
+	}
+
+	return stat.Mode()&os.ModeCharDevice != 0
+}
+
+func (c *Context) newConsoleWriter(out io.Writer, colorful bool) zerolog.ConsoleWriter {
+	// nolint:exhaustruct
+	output := zerolog.ConsoleWriter{Out: out, NoColor: !colorful, TimeFormat: time.RFC3339}
+	output.FormatLevel = func(lvlRaw interface{}) string {
+		var formattedLvl string
+
+		if lvl, ok := lvlRaw.(string); ok {
+			lvl = strings.ToUpper(lvl)
+
+			if !colorful {
+				return fmt.Sprintf("| %-5s |", lvl)
+			}
+
+			switch lvl {
+			case "DEBUG":
+				formattedLvl = fmt.Sprintf("\x1b[30m%-5s\x1b[0m", lvl)
+			case "ERROR":
+				formattedLvl = fmt.Sprintf("\x1b[31m%-5s\x1b[0m", lvl)
+			case "FATAL":
+				formattedLvl = fmt.Sprintf("\x1b[35m%-5s\x1b[0m", lvl)
+			case "INFO":
+				formattedLvl = fmt.Sprintf("\x1b[32m%-5s\x1b[0m", lvl)
+			case "PANIC":
+				formattedLvl = fmt.Sprintf("\x1b[36m%-5s\x1b[0m", lvl)
+			case "WARN":
+				formattedLvl = fmt.Sprintf("\x1b[33m%-5s\x1b[0m", lvl)
+			default:
+				formattedLvl = lvl
+			}
+		}
+
+		return fmt.Sprintf("| %s |", formattedLvl)
+	}
+
+	return output
+}
+
+// RotatingFile is a log file which will be rotated when it's size will
+// exceed maximum. Rotated files are named like "opensaps.log.1", where
+// bigger number means older file.
+type rotatingFile struct {
+	file     *os.File
+	path     string
+	maxSize  int64
+	size     int64
+	maxFiles int
+}
+
+func newRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	// nolint:exhaustruct
+	rf := &rotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+
+	return rf, nil
+}
+
+func (rf *rotatingFile) Close() error {
+	return rf.file.Close()
+}
+
+func (rf *rotatingFile) open() error {
+	// nolint:gomnd
+	file, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	rf.file = file
+	rf.size = stat.Size()
+
+	return nil
+}
+
+func (rf *rotatingFile) rotate() error {
+	rf.file.Close()
+
+	for idx := rf.maxFiles - 1; idx > 0; idx-- {
+		err := os.Rename(rf.path+"."+strconv.Itoa(idx), rf.path+"."+strconv.Itoa(idx+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to rotate log file: %w", err)
+		}
+	}
+
+	if err := os.Rename(rf.path, rf.path+".1"); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+
+	return rf.open()
+}
+
+// Writes are serialized by logOutput, so there is no locking here.
+func (rf *rotatingFile) Write(data []byte) (int, error) {
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(data)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			// Try to continue writing into same file.
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+
+			if rf.open() != nil {
+				return 0, err
+			}
+		}
+	}
+
+	n, err := rf.file.Write(data)
+	rf.size += int64(n)
+
+	return n, err
+}
@@ -0,0 +1,121 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package context
+
+import (
+	"encoding/json"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// Replacement for secret values.
+	redactedValue = "***"
+	// Shorter values aren't considered as secrets, otherwise we'll mask
+	// half of log output.
+	redactorMinSecretLength = 4
+)
+
+// Patterns for secrets we might not know about, like tokens in URLs.
+var redactorPatterns = []struct {
+	regexp      *regexp.Regexp
+	replacement string
+}{
+	// Matrix access tokens in query string.
+	{regexp.MustCompile(`(access_token=)[^&\s"\\]+`), "${1}" + redactedValue},
+	// Telegram bot tokens in Bot API URLs.
+	{regexp.MustCompile(`(/bot)[0-9]+:[A-Za-z0-9_-]+`), "${1}" + redactedValue},
+	// Credentials in URLs, like proxy URLs.
+	{regexp.MustCompile(`(://[^:/@\s"]+:)[^@/\s"]+@`), "${1}" + redactedValue + "@"},
+}
+
+// Redactor is a writer that masks secret values in log output before
+// passing it to actual output.
+type Redactor struct {
+	out      io.Writer
+	replacer *strings.Replacer
+	secrets  map[string]struct{}
+	mutex    sync.RWMutex
+}
+
+func newRedactor(out io.Writer) *Redactor {
+	// nolint:exhaustruct
+	return &Redactor{
+		out:      out,
+		replacer: strings.NewReplacer(),
+		secrets:  make(map[string]struct{}),
+	}
+}
+
+// AddSecret adds value that should never appear in log output.
+func (r *Redactor) AddSecret(value string) {
+	if len(value) < redactorMinSecretLength {
+		return
+	}
+
+	// Value might appear in JSON-encoded form, e.g. in JSON logs.
+	encoded, _ := json.Marshal(value)
+	encodedValue := strings.Trim(string(encoded), `"`)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.secrets[value] = struct{}{}
+	r.secrets[encodedValue] = struct{}{}
+
+	// Longer secrets should be replaced first as shorter ones might be
+	// their parts.
+	secrets := make([]string, 0, len(r.secrets))
+	for secret := range r.secrets {
+		secrets = append(secrets, secret)
+	}
+
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+
+	replacements := make([]string, 0, len(secrets)*2)
+	for _, secret := range secrets {
+		replacements = append(replacements, secret, redactedValue)
+	}
+
+	r.replacer = strings.NewReplacer(replacements...)
+}
+
+// Redact returns passed string with secrets masked.
+func (r *Redactor) Redact(data string) string {
+	for _, pattern := range redactorPatterns {
+		data = pattern.regexp.ReplaceAllString(data, pattern.replacement)
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.replacer.Replace(data)
+}
+
+func (r *Redactor) Write(data []byte) (int, error) {
+	if _, err := io.WriteString(r.out, r.Redact(string(data))); err != nil {
+		return 0, err
+	}
+
+	// Written data length differs from passed one, but callers should
+	// think that everything was written.
+	return len(data), nil
+}
@@ -5,5 +5,5 @@ go 1.13
 require (
 	github.com/rs/zerolog v1.26.0
 	go.dev.pztrn.name/flagger v0.0.0-20191215171500-5e6aeb0e0620
-	gopkg.in/yaml.v2 v2.2.7
+	gopkg.in/yaml.v3 v3.0.1
 )
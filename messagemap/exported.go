@@ -0,0 +1,50 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package messagemap
+
+import (
+	"sync"
+	"time"
+
+	"go.dev.pztrn.name/opensaps/context"
+	messagemapinterface "go.dev.pztrn.name/opensaps/messagemap/interface"
+)
+
+var (
+	ctx *context.Context
+	// Slack messages by their timestamps.
+	entries      map[string]messagemapinterface.Entry
+	entriesMutex sync.Mutex
+	// File where entries are stored. Empty if they're kept in memory only.
+	file string
+	// Shows that entries were changed after last write. Protected by
+	// entriesMutex.
+	dirty bool
+	// Scheduled write, nil if there is none. Protected by entriesMutex.
+	saveTimer *time.Timer
+	// Serializes writes to file.
+	writeMutex sync.Mutex
+)
+
+func New(cc *context.Context) {
+	ctx = cc
+	entries = make(map[string]messagemapinterface.Entry)
+
+	mm := MessageMap{}
+	ctx.RegisterMessageMapInterface(messagemapinterface.MessageMapInterface(mm))
+}
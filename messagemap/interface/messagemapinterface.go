@@ -0,0 +1,76 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package messagemapinterface
+
+import (
+	"time"
+)
+
+// RemoteMessage is a message created by pusher for Slack message.
+type RemoteMessage struct {
+	Pusher     string `json:"pusher"`
+	Connection string `json:"connection"`
+	// ID is a message ID in remote service, e.g. Matrix event ID or
+	// Telegram message ID.
+	ID string `json:"id"`
+}
+
+// Entry describes Slack message sent with Web API.
+// nolint:tagliatelle
+type Entry struct {
+	CreatedAt time.Time `json:"created_at"`
+	// Bot is a name of Web API bot which sent message.
+	Bot      string          `json:"bot"`
+	Channel  string          `json:"channel"`
+	Messages []RemoteMessage `json:"messages"`
+	// Deleted shows that message deletion was requested. Remote messages
+	// are kept until they will be deleted by delivery queue.
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// MessageID returns ID of message created by passed pusher and
+// connection. Empty string is returned if there is no such message.
+func (e Entry) MessageID(pusher string, connection string) string {
+	for _, message := range e.Messages {
+		if message.Pusher == pusher && message.Connection == connection {
+			return message.ID
+		}
+	}
+
+	return ""
+}
+
+type MessageMapInterface interface {
+	// Add stores remote message created for Slack message. Ignored if
+	// Slack message wasn't registered or was already removed.
+	Add(ts string, message RemoteMessage)
+	// Get returns Slack message with passed timestamp.
+	Get(ts string) (Entry, bool)
+	Initialize()
+	// MarkDeleted marks Slack message as deleted, so it can't be changed
+	// anymore.
+	MarkDeleted(ts string)
+	// Register stores new Slack message. Remote messages will be added
+	// to it when they will be delivered.
+	Register(ts string, bot string, channel string)
+	Reload()
+	// Remove forgets Slack message.
+	Remove(ts string)
+	// Shutdown writes unsaved changes to file.
+	Shutdown()
+}
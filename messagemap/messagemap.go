@@ -0,0 +1,276 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package messagemap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	messagemapinterface "go.dev.pztrn.name/opensaps/messagemap/interface"
+)
+
+// Delay before changes are written to file, so burst of Web API requests
+// will be saved with single write.
+const saveDelay = time.Second
+
+// MessageMap keeps IDs of messages created in remote services for
+// Slack messages sent with Web API, so they can be updated or deleted
+// later. Changes are saved to file in batches, see saveDelay.
+type MessageMap struct{}
+
+func (mm MessageMap) Add(ts string, message messagemapinterface.RemoteMessage) {
+	entriesMutex.Lock()
+	defer entriesMutex.Unlock()
+
+	entry, found := entries[ts]
+	if !found {
+		ctx.Log.Debug().Str("ts", ts).Msg("Slack message is unknown, remote message ID won't be stored")
+
+		return
+	}
+
+	entry.Messages = append(entry.Messages, message)
+	entries[ts] = entry
+
+	mm.save()
+}
+
+func (mm MessageMap) Get(ts string) (messagemapinterface.Entry, bool) {
+	entriesMutex.Lock()
+	defer entriesMutex.Unlock()
+
+	entry, found := entries[ts]
+	if !found || time.Since(entry.CreatedAt) > ctx.Config.GetConfig().WebAPI.GetMessagesRetention() {
+		// nolint:exhaustruct
+		return messagemapinterface.Entry{}, false
+	}
+
+	// Messages slice shouldn't be shared with caller.
+	entry.Messages = append([]messagemapinterface.RemoteMessage{}, entry.Messages...)
+
+	return entry, true
+}
+
+func (mm MessageMap) Initialize() {
+	file = ctx.Config.GetConfig().WebAPI.MessagesFile
+	if file == "" {
+		ctx.Log.Debug().Msg("Web API messages file isn't configured, message IDs will be kept in memory only")
+
+		return
+	}
+
+	if err := mm.load(); err != nil {
+		ctx.Log.Error().Err(err).Str("file", file).Msg("Failed to load Web API messages, starting with empty list")
+	}
+
+	ctx.Log.Info().Str("file", file).Int("messages", len(entries)).Msg("Web API messages loaded")
+}
+
+func (mm MessageMap) MarkDeleted(ts string) {
+	entriesMutex.Lock()
+	defer entriesMutex.Unlock()
+
+	entry, found := entries[ts]
+	if !found {
+		return
+	}
+
+	entry.Deleted = true
+	entries[ts] = entry
+
+	mm.save()
+}
+
+func (mm MessageMap) Register(ts string, bot string, channel string) {
+	entriesMutex.Lock()
+	defer entriesMutex.Unlock()
+
+	mm.prune()
+
+	entries[ts] = messagemapinterface.Entry{
+		CreatedAt: time.Now(),
+		Bot:       bot,
+		Channel:   channel,
+		Messages:  []messagemapinterface.RemoteMessage{},
+		Deleted:   false,
+	}
+
+	mm.save()
+}
+
+// Retention is read on every call, so only file location change should
+// be handled here.
+func (mm MessageMap) Reload() {
+	newFile := ctx.Config.GetConfig().WebAPI.MessagesFile
+	if newFile != file {
+		ctx.Log.Warn().Str("file", file).Str("new_file", newFile).
+			Msg("Web API messages file can't be changed without restart, will continue to use current one")
+	}
+}
+
+func (mm MessageMap) Remove(ts string) {
+	entriesMutex.Lock()
+	defer entriesMutex.Unlock()
+
+	if _, found := entries[ts]; !found {
+		return
+	}
+
+	delete(entries, ts)
+
+	mm.save()
+}
+
+func (mm MessageMap) Shutdown() {
+	entriesMutex.Lock()
+	if saveTimer != nil {
+		saveTimer.Stop()
+		saveTimer = nil
+	}
+	entriesMutex.Unlock()
+
+	mm.flush()
+}
+
+func (mm MessageMap) load() error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return fmt.Errorf("failed to read messages file: %w", err)
+	}
+
+	entriesMutex.Lock()
+	defer entriesMutex.Unlock()
+
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("failed to decode messages file: %w", err)
+	}
+
+	// File might contain "null", which resets map.
+	if entries == nil {
+		entries = make(map[string]messagemapinterface.Entry)
+	}
+
+	mm.prune()
+
+	return nil
+}
+
+// Removes entries older than configured retention. Should be called
+// with entries mutex locked.
+func (mm MessageMap) prune() {
+	retention := ctx.Config.GetConfig().WebAPI.GetMessagesRetention()
+
+	for ts, entry := range entries {
+		if time.Since(entry.CreatedAt) > retention {
+			delete(entries, ts)
+		}
+	}
+}
+
+// Schedules write of changed entries to file. Should be called with
+// entries mutex locked.
+func (mm MessageMap) save() {
+	if file == "" {
+		return
+	}
+
+	dirty = true
+
+	if saveTimer == nil {
+		saveTimer = time.AfterFunc(saveDelay, mm.flush)
+	}
+}
+
+// Writes entries to file if they were changed. Entries are encoded with
+// entries mutex locked, but file is written without it, so requests
+// won't wait for disk.
+func (mm MessageMap) flush() {
+	writeMutex.Lock()
+	defer writeMutex.Unlock()
+
+	entriesMutex.Lock()
+	saveTimer = nil
+
+	if !dirty {
+		entriesMutex.Unlock()
+
+		return
+	}
+
+	data, err := json.Marshal(entries)
+	dirty = false
+	entriesMutex.Unlock()
+
+	if err == nil {
+		err = mm.write(data)
+	}
+
+	if err != nil {
+		ctx.Log.Error().Err(err).Str("file", file).Msg("Failed to save Web API messages, will retry on next change")
+
+		entriesMutex.Lock()
+		dirty = true
+		entriesMutex.Unlock()
+	}
+}
+
+// Writes data to file. Data is written into temporary file first, synced
+// to disk and then renamed, so we'll never have partially written file.
+func (mm MessageMap) write(data []byte) error {
+	// nolint:gomnd
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return fmt.Errorf("failed to create messages file directory: %w", err)
+	}
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(file), ".tmp-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for messages: %w", err)
+	}
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+
+		return fmt.Errorf("failed to write messages: %w", err)
+	}
+
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+
+		return fmt.Errorf("failed to sync messages file: %w", err)
+	}
+
+	tmpFile.Close()
+
+	if err := os.Rename(tmpFile.Name(), file); err != nil {
+		os.Remove(tmpFile.Name())
+
+		return fmt.Errorf("failed to write messages: %w", err)
+	}
+
+	return nil
+}
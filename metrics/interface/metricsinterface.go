@@ -0,0 +1,47 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package metricsinterface
+
+import (
+	"io"
+	"time"
+)
+
+// Actions recorded by MessagePushed.
+const (
+	PushActionPost   = "post"
+	PushActionUpdate = "update"
+	PushActionDelete = "delete"
+)
+
+type MetricsInterface interface {
+	Initialize()
+	// MatrixLoggedIn sets Matrix connection login state.
+	MatrixLoggedIn(connection string, loggedIn bool)
+	// MessagePushed records result of attempt to push, update or delete
+	// message, see PushAction* constants.
+	MessagePushed(pusher string, connection string, action string, duration time.Duration, err error)
+	// ParseFailed records failure to decode webhook's payload.
+	ParseFailed(webhook string)
+	// QueueDepth sets number of messages waiting for delivery.
+	QueueDepth(pusher string, connection string, depth int)
+	// RequestReceived records request received for webhook.
+	RequestReceived(webhook string)
+	// WriteMetrics writes all metrics in Prometheus text format.
+	WriteMetrics(writer io.Writer) error
+}
@@ -0,0 +1,112 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package metrics
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	pusherinterface "go.dev.pztrn.name/opensaps/pushers/interface"
+)
+
+// Push duration histogram buckets, in seconds.
+// nolint:gochecknoglobals,gomnd
+var pushDurationBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type Metrics struct{}
+
+func (m Metrics) Initialize() {
+	ctx.Log.Info().Msg("Initializing metrics...")
+
+	requestsReceived = newVector(typeCounter, "opensaps_webhook_requests_total",
+		"Number of requests received for webhook.", []string{"webhook"}, nil)
+	parseFailures = newVector(typeCounter, "opensaps_webhook_parse_failures_total",
+		"Number of requests which payload failed to decode.", []string{"webhook"}, nil)
+	messagesPushed = newVector(typeCounter, "opensaps_messages_pushed_total",
+		"Number of messages successfully pushed, updated or deleted.", []string{"pusher", "connection", "action"}, nil)
+	pushDuration = newVector(typeHistogram, "opensaps_push_duration_seconds",
+		"Time taken by message push attempt.", []string{"pusher", "connection", "action"}, pushDurationBuckets)
+	pushErrors = newVector(typeCounter, "opensaps_push_errors_total",
+		"Number of failed message push attempts by error type.", []string{"pusher", "connection", "action", "type"}, nil)
+	queueDepth = newVector(typeGauge, "opensaps_queue_depth",
+		"Number of messages waiting for delivery in queue.", []string{"pusher", "connection"}, nil)
+	matrixLoggedIn = newVector(typeGauge, "opensaps_matrix_logged_in",
+		"Whether Matrix connection is logged in and joined room (1) or not (0).", []string{"connection"}, nil)
+}
+
+func (m Metrics) MatrixLoggedIn(connection string, loggedIn bool) {
+	var value float64
+	if loggedIn {
+		value = 1
+	}
+
+	matrixLoggedIn.set(value, connection)
+}
+
+func (m Metrics) MessagePushed(pusher string, connection string, action string, duration time.Duration, err error) {
+	pushDuration.observe(duration.Seconds(), pusher, connection, action)
+
+	if err == nil {
+		messagesPushed.add(1, pusher, connection, action)
+
+		return
+	}
+
+	pushErrors.add(1, pusher, connection, action, m.errorType(err))
+}
+
+func (m Metrics) ParseFailed(webhook string) {
+	parseFailures.add(1, webhook)
+}
+
+func (m Metrics) QueueDepth(pusher string, connection string, depth int) {
+	queueDepth.set(float64(depth), pusher, connection)
+}
+
+func (m Metrics) RequestReceived(webhook string) {
+	requestsReceived.add(1, webhook)
+}
+
+func (m Metrics) WriteMetrics(writer io.Writer) error {
+	for _, metric := range []*vector{
+		requestsReceived, parseFailures, messagesPushed, pushDuration, pushErrors, queueDepth, matrixLoggedIn,
+	} {
+		if err := metric.write(writer); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns short error type for push errors metric.
+func (m Metrics) errorType(err error) string {
+	switch {
+	case errors.Is(err, pusherinterface.ErrPusherNotFound):
+		return "pusher_not_found"
+	case errors.Is(err, pusherinterface.ErrConnectionNotFound):
+		return "connection_not_found"
+	case errors.Is(err, pusherinterface.ErrConnectionNotReady):
+		return "connection_not_ready"
+	case pusherinterface.IsTemporary(err):
+		return "temporary"
+	default:
+		return "permanent"
+	}
+}
@@ -0,0 +1,196 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metric types.
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// Separates label values in series key.
+const labelsSeparator = "\xff"
+
+// Series is a single metric value (or histogram) with concrete labels.
+type series struct {
+	labelValues []string
+	// Value for counters and gauges, sum for histograms.
+	value float64
+	// Histogram's observations count and per-bucket (not cumulative)
+	// counts.
+	count        uint64
+	bucketCounts []uint64
+}
+
+// Vector is a metric with labels, in Prometheus terms.
+type vector struct {
+	series     map[string]*series
+	name       string
+	help       string
+	metricType string
+	labels     []string
+	// Upper bounds for histogram buckets.
+	buckets []float64
+	mutex   sync.Mutex
+}
+
+func newVector(metricType string, name string, help string, labels []string, buckets []float64) *vector {
+	return &vector{
+		series:     make(map[string]*series),
+		name:       name,
+		help:       help,
+		metricType: metricType,
+		labels:     labels,
+		buckets:    buckets,
+		mutex:      sync.Mutex{},
+	}
+}
+
+// Adds value to counter or gauge.
+func (v *vector) add(value float64, labelValues ...string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.getSeries(labelValues).value += value
+}
+
+// Should be called with mutex locked.
+func (v *vector) getSeries(labelValues []string) *series {
+	key := strings.Join(labelValues, labelsSeparator)
+
+	s, found := v.series[key]
+	if !found {
+		// nolint:exhaustruct
+		s = &series{labelValues: labelValues, bucketCounts: make([]uint64, len(v.buckets))}
+		v.series[key] = s
+	}
+
+	return s
+}
+
+// Records observation in histogram.
+func (v *vector) observe(value float64, labelValues ...string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	s := v.getSeries(labelValues)
+	s.value += value
+	s.count++
+
+	for idx, bound := range v.buckets {
+		if value <= bound {
+			s.bucketCounts[idx]++
+
+			break
+		}
+	}
+}
+
+// Sets gauge value.
+func (v *vector) set(value float64, labelValues ...string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.getSeries(labelValues).value = value
+}
+
+// Writes metric in Prometheus text exposition format.
+func (v *vector) write(writer io.Writer) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	var builder strings.Builder
+
+	fmt.Fprintf(&builder, "# HELP %s %s\n", v.name, v.help)
+	fmt.Fprintf(&builder, "# TYPE %s %s\n", v.name, v.metricType)
+
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := v.series[key]
+
+		if v.metricType != typeHistogram {
+			fmt.Fprintf(&builder, "%s%s %s\n", v.name, v.formatLabels(s.labelValues, ""), formatValue(s.value))
+
+			continue
+		}
+
+		var cumulative uint64
+
+		for idx, bound := range v.buckets {
+			cumulative += s.bucketCounts[idx]
+			fmt.Fprintf(&builder, "%s_bucket%s %d\n", v.name, v.formatLabels(s.labelValues, formatValue(bound)), cumulative)
+		}
+
+		fmt.Fprintf(&builder, "%s_bucket%s %d\n", v.name, v.formatLabels(s.labelValues, "+Inf"), s.count)
+		fmt.Fprintf(&builder, "%s_sum%s %s\n", v.name, v.formatLabels(s.labelValues, ""), formatValue(s.value))
+		fmt.Fprintf(&builder, "%s_count%s %d\n", v.name, v.formatLabels(s.labelValues, ""), s.count)
+	}
+
+	_, err := io.WriteString(writer, builder.String())
+
+	return err
+}
+
+// Formats labels like '{pusher="matrix",connection="test"}'. If bucket
+// isn't empty - "le" label will be added.
+func (v *vector) formatLabels(labelValues []string, bucket string) string {
+	pairs := make([]string, 0, len(labelValues)+1)
+
+	for idx, value := range labelValues {
+		pairs = append(pairs, v.labels[idx]+`="`+escapeLabelValue(value)+`"`)
+	}
+
+	if bucket != "" {
+		pairs = append(pairs, `le="`+bucket+`"`)
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
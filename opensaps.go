@@ -22,11 +22,15 @@ import (
 	"os/signal"
 	"syscall"
 
+	"go.dev.pztrn.name/opensaps/cli"
 	"go.dev.pztrn.name/opensaps/config"
 	"go.dev.pztrn.name/opensaps/context"
+	"go.dev.pztrn.name/opensaps/messagemap"
+	"go.dev.pztrn.name/opensaps/metrics"
 	defaultparser "go.dev.pztrn.name/opensaps/parsers/default"
 	matrixpusher "go.dev.pztrn.name/opensaps/pushers/matrix"
 	telegrampusher "go.dev.pztrn.name/opensaps/pushers/telegram"
+	"go.dev.pztrn.name/opensaps/queue"
 	"go.dev.pztrn.name/opensaps/slack"
 )
 
@@ -36,13 +40,25 @@ func main() {
 
 	config.New(ctx)
 
-	ctx.Log.Info().Msg("Launching OpenSAPS...")
+	// Subcommands should be removed from arguments before flags parsing.
+	subcommand, subcommandArgs := cli.ExtractSubcommand()
 
 	ctx.Flagger.Parse()
+	ctx.ConfigureLogger()
 	ctx.Config.InitializeLater()
+
+	// Subcommands load configuration themselves, if they need it.
+	if subcommand != "" {
+		os.Exit(cli.Run(ctx, subcommand, subcommandArgs))
+	}
+
+	ctx.Log.Info().Msg("Launching OpenSAPS...")
+
 	ctx.Config.LoadConfigurationFromFile()
+	ctx.ConfigureLogger()
 
-	slack.New(ctx)
+	// Metrics should be initialized before everything that reports them.
+	metrics.New(ctx)
 
 	// Initialize parsers.
 	defaultparser.New(ctx)
@@ -51,6 +67,15 @@ func main() {
 	matrixpusher.New(ctx)
 	telegrampusher.New(ctx)
 
+	// Queue stores IDs of delivered Web API messages in messages map.
+	messagemap.New(ctx)
+
+	// Delivery queue should be initialized after pushers as it might
+	// start delivering messages left from previous run right away.
+	queue.New(ctx)
+
+	slack.New(ctx)
+
 	// CTRL+C handler.
 	signalHandler := make(chan os.Signal, 1)
 	shutdownDone := make(chan bool, 1)
@@ -63,6 +88,17 @@ func main() {
 		shutdownDone <- true
 	}()
 
+	// SIGHUP handler for configuration reloading.
+	reloadHandler := make(chan os.Signal, 1)
+
+	signal.Notify(reloadHandler, syscall.SIGHUP)
+
+	go func() {
+		for range reloadHandler {
+			ctx.Reload()
+		}
+	}()
+
 	<-shutdownDone
 	os.Exit(0)
 }
@@ -0,0 +1,243 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package defaultparser
+
+import (
+	"strconv"
+	"strings"
+
+	parserinterface "go.dev.pztrn.name/opensaps/parsers/interface"
+	slackmessage "go.dev.pztrn.name/opensaps/slack/message"
+	"go.dev.pztrn.name/opensaps/slack/mrkdwn"
+)
+
+// Converts Block Kit blocks into message blocks. Interactive elements
+// can't be used outside of Slack, so they're replaced with textual
+// representation.
+// nolint:exhaustruct
+func (dp DefaultParser) parseBlocks(msg *parserinterface.ParsedMessage, blocks []slackmessage.Block) {
+	for _, block := range blocks {
+		switch block.Type {
+		case "section":
+			if block.Text != nil {
+				msg.AddBlock(parserinterface.Block{Type: parserinterface.BlockText, Text: block.Text.Text, Format: dp.textFormat(block.Text)})
+			}
+
+			if len(block.Fields) != 0 {
+				fields := make([]parserinterface.Field, 0, len(block.Fields))
+
+				for idx := range block.Fields {
+					fields = append(fields, parserinterface.Field{
+						Value: block.Fields[idx].Text, Short: true, Format: dp.textFormat(&block.Fields[idx]),
+					})
+				}
+
+				msg.AddBlock(parserinterface.Block{Type: parserinterface.BlockFields, Fields: fields})
+			}
+
+			if block.Accessory != nil {
+				dp.parseAccessory(msg, *block.Accessory)
+			}
+		case "header":
+			if block.Text != nil {
+				msg.AddBlock(parserinterface.Block{Type: parserinterface.BlockHeader, Text: block.Text.Text})
+			}
+		case "divider":
+			msg.AddBlock(parserinterface.Block{Type: parserinterface.BlockDivider})
+		case "image":
+			altText := block.AltText
+			if block.Title != nil && block.Title.Text != "" {
+				altText = block.Title.Text
+			}
+
+			msg.AddBlock(parserinterface.Block{
+				Type: parserinterface.BlockImage, Image: parserinterface.Image{URL: block.ImageURL, AltText: altText},
+			})
+		case "video":
+			title := block.AltText
+			if block.Title != nil && block.Title.Text != "" {
+				title = block.Title.Text
+			}
+
+			msg.AddBlock(parserinterface.Block{Type: parserinterface.BlockHeader, Text: title, URL: block.TitleURL})
+		case "context":
+			msg.AddBlock(parserinterface.Block{Type: parserinterface.BlockContext, Text: dp.joinElements(block.Elements, " ")})
+		case "actions":
+			msg.AddBlock(parserinterface.Block{Type: parserinterface.BlockContext, Text: dp.joinElements(block.Elements, " · ")})
+		case "rich_text":
+			msg.AddText(dp.richTextToMrkdwn(block.Elements))
+		default:
+			// Unknown, input and file blocks. Show text if it is present.
+			if block.Text != nil {
+				msg.AddBlock(parserinterface.Block{Type: parserinterface.BlockText, Text: block.Text.Text, Format: dp.textFormat(block.Text)})
+			}
+		}
+	}
+}
+
+// Section's accessory is displayed after section's text.
+// nolint:exhaustruct
+func (dp DefaultParser) parseAccessory(msg *parserinterface.ParsedMessage, element slackmessage.BlockElement) {
+	if element.Type == "image" {
+		msg.AddBlock(parserinterface.Block{
+			Type: parserinterface.BlockImage, Image: parserinterface.Image{URL: element.ImageURL, AltText: element.AltText},
+		})
+
+		return
+	}
+
+	msg.AddBlock(parserinterface.Block{Type: parserinterface.BlockContext, Text: dp.elementToMrkdwn(element)})
+}
+
+// Converts context or actions block element to mrkdwn.
+func (dp DefaultParser) elementToMrkdwn(element slackmessage.BlockElement) string {
+	switch element.Type {
+	case "mrkdwn":
+		return element.Text.Text
+	case "plain_text":
+		return mrkdwn.Escape(element.Text.Text)
+	case "image":
+		if element.AltText == "" {
+			return mrkdwn.Link(element.ImageURL, "")
+		}
+
+		return mrkdwn.Link(element.ImageURL, element.AltText)
+	case "button":
+		if element.URL != "" {
+			return mrkdwn.Link(element.URL, element.Text.Text)
+		}
+
+		return "[" + mrkdwn.Escape(element.Text.Text) + "]"
+	default:
+		// Selects, date pickers, overflow menus, etc.
+		label := element.Type
+		if element.Placeholder != nil && element.Placeholder.Text != "" {
+			label = element.Placeholder.Text
+		}
+
+		return "[" + mrkdwn.Escape(label) + "]"
+	}
+}
+
+func (dp DefaultParser) joinElements(elements []slackmessage.BlockElement, separator string) string {
+	parts := make([]string, 0, len(elements))
+
+	for _, element := range elements {
+		if text := dp.elementToMrkdwn(element); text != "" {
+			parts = append(parts, text)
+		}
+	}
+
+	return strings.Join(parts, separator)
+}
+
+// Converts rich text elements to mrkdwn.
+func (dp DefaultParser) richTextToMrkdwn(elements []slackmessage.BlockElement) string {
+	var builder strings.Builder
+
+	for _, element := range elements {
+		switch element.Type {
+		case "rich_text_section":
+			// Lists, quotes and preformatted texts are always displayed
+			// on separate lines.
+			builder.WriteString(strings.TrimSuffix(dp.richTextSectionToMrkdwn(element.Elements), "\n") + "\n")
+		case "rich_text_list":
+			for idx, item := range element.Elements {
+				marker := "• "
+				if element.Style.Name == "ordered" {
+					marker = strconv.Itoa(idx+1) + ". "
+				}
+
+				builder.WriteString(strings.Repeat("    ", element.Indent) + marker + dp.richTextSectionToMrkdwn(item.Elements) + "\n")
+			}
+		case "rich_text_preformatted":
+			builder.WriteString("```\n" + dp.richTextSectionToMrkdwn(element.Elements) + "\n```\n")
+		case "rich_text_quote":
+			quote := dp.richTextSectionToMrkdwn(element.Elements)
+			builder.WriteString("> " + strings.ReplaceAll(quote, "\n", "\n> ") + "\n")
+		}
+	}
+
+	return strings.TrimSuffix(builder.String(), "\n")
+}
+
+// Converts rich text section's elements to mrkdwn.
+func (dp DefaultParser) richTextSectionToMrkdwn(elements []slackmessage.BlockElement) string {
+	var builder strings.Builder
+
+	for _, element := range elements {
+		switch element.Type {
+		case "text":
+			builder.WriteString(dp.applyRichTextStyle(mrkdwn.Escape(element.Text.Text), element.Style))
+		case "link":
+			builder.WriteString(dp.applyRichTextStyle(mrkdwn.Link(element.URL, element.Text.Text), element.Style))
+		case "user":
+			builder.WriteString("<@" + element.UserID + ">")
+		case "channel":
+			builder.WriteString("<#" + element.ChannelID + ">")
+		case "usergroup":
+			builder.WriteString("<!subteam^" + element.UsergroupID + ">")
+		case "broadcast":
+			builder.WriteString("<!" + element.Range + ">")
+		case "emoji":
+			builder.WriteString(":" + element.Name + ":")
+		}
+	}
+
+	return builder.String()
+}
+
+// Wraps text into mrkdwn formatting markers. Leading and trailing
+// whitespace is kept outside of markers as mrkdwn requires.
+func (dp DefaultParser) applyRichTextStyle(text string, style slackmessage.RichTextStyle) string {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return text
+	}
+
+	formatted := trimmed
+
+	if style.Code {
+		formatted = "`" + formatted + "`"
+	}
+
+	if style.Strike {
+		formatted = "~" + formatted + "~"
+	}
+
+	if style.Italic {
+		formatted = "_" + formatted + "_"
+	}
+
+	if style.Bold {
+		formatted = "*" + formatted + "*"
+	}
+
+	start := strings.Index(text, trimmed)
+
+	return text[:start] + formatted + text[start+len(trimmed):]
+}
+
+// Returns format for Block Kit text object.
+func (dp DefaultParser) textFormat(text *slackmessage.TextObject) mrkdwn.Format {
+	if text.IsMrkdwn() {
+		return mrkdwn.FormatMrkdwn
+	}
+
+	return mrkdwn.FormatPlain
+}
@@ -18,10 +18,9 @@
 package defaultparser
 
 import (
-	"regexp"
-	"strings"
-
+	parserinterface "go.dev.pztrn.name/opensaps/parsers/interface"
 	slackmessage "go.dev.pztrn.name/opensaps/slack/message"
+	"go.dev.pztrn.name/opensaps/slack/mrkdwn"
 )
 
 type DefaultParser struct{}
@@ -30,29 +29,108 @@ func (dp DefaultParser) Initialize() {
 	c.Log.Info().Msg("Initializing default parser...")
 }
 
-func (dp DefaultParser) ParseMessage(message slackmessage.SlackMessage) map[string]interface{} {
+func (dp DefaultParser) ParseMessage(message slackmessage.SlackMessage) parserinterface.ParsedMessage {
 	c.Log.Debug().Msg("Parsing default message...")
 
-	msg := message.Text + "\n"
-	for _, attachment := range message.Attachments {
-		msg += attachment.Text + "\n"
+	// Texts are passed in Slack's mrkdwn, pushers will render them
+	// (including links) as they need.
+	// nolint:exhaustruct
+	msg := parserinterface.ParsedMessage{}
+
+	// If blocks are present - text is used only for notifications.
+	if len(message.Blocks) != 0 {
+		dp.parseBlocks(&msg, message.Blocks)
+	} else {
+		msg.AddText(message.Text)
 	}
 
-	// Remove line break in very beginning, if present.
-	if strings.Contains(msg[0:3], "\n") {
-		c.Log.Debug().Msg("Initial br found, removing")
+	for idx, attachment := range message.Attachments {
+		// Separate attachments from each other.
+		if idx != 0 {
+			// nolint:exhaustruct
+			msg.AddBlock(parserinterface.Block{Type: parserinterface.BlockDivider})
+		}
+
+		dp.parseAttachment(&msg, attachment)
+
+		if msg.Color == "" {
+			msg.Color = attachment.Color
+		}
+	}
+
+	msg.Severity = parserinterface.SeverityFromColor(msg.Color)
+	msg.CollectLinks()
+
+	c.Log.Debug().Msgf("Found links: %+v", msg.Links)
 
-		msg = strings.Replace(msg, "\n", "", 1)
+	return msg
+}
+
+// Converts legacy attachment into message blocks in order Slack displays
+// them.
+// nolint:exhaustruct
+func (dp DefaultParser) parseAttachment(msg *parserinterface.ParsedMessage, attachment slackmessage.SlackAttachments) {
+	msg.AddBlock(parserinterface.Block{
+		Type: parserinterface.BlockText, Text: attachment.Pretext, Format: dp.getFormat(attachment, "pretext"),
+	})
+
+	if attachment.AuthorName != "" {
+		author := mrkdwn.Escape(attachment.AuthorName)
+		if attachment.AuthorLink != "" {
+			author = mrkdwn.Link(attachment.AuthorLink, attachment.AuthorName)
+		}
+
+		msg.AddBlock(parserinterface.Block{Type: parserinterface.BlockContext, Text: author, Format: mrkdwn.FormatLinks})
 	}
 
-	// Get all links from message.
-	r := regexp.MustCompile(`<{1}([\pL\pP\pN]+)\|{1}([\pL\pP\pN\pZs]+)>{1}`)
-	foundLinks := r.FindAllStringSubmatch(msg, -1)
-	c.Log.Debug().Msgf("Found links: %+v", foundLinks)
+	if attachment.Title != "" {
+		msg.AddBlock(parserinterface.Block{Type: parserinterface.BlockHeader, Text: attachment.Title, URL: attachment.TitleLink})
+	}
+
+	text := attachment.Text
+	// Fallback is a text for clients which can't display attachments. Use
+	// it if there is nothing else to show.
+	if text == "" && attachment.Pretext == "" && attachment.Title == "" && len(attachment.Fields) == 0 &&
+		len(attachment.Blocks) == 0 {
+		text = attachment.Fallback
+	}
 
-	data := make(map[string]interface{})
-	data["message"] = msg
-	data["links"] = foundLinks
+	msg.AddBlock(parserinterface.Block{Type: parserinterface.BlockText, Text: text, Format: dp.getFormat(attachment, "text")})
+
+	dp.parseBlocks(msg, attachment.Blocks)
+
+	if len(attachment.Fields) != 0 {
+		fields := make([]parserinterface.Field, 0, len(attachment.Fields))
+
+		for _, field := range attachment.Fields {
+			fields = append(fields, parserinterface.Field{
+				Title: field.Title, Value: field.Value, Short: field.Short, Format: dp.getFormat(attachment, "fields"),
+			})
+		}
+
+		msg.AddBlock(parserinterface.Block{Type: parserinterface.BlockFields, Fields: fields})
+	}
+
+	if attachment.ImageURL != "" {
+		msg.AddBlock(parserinterface.Block{Type: parserinterface.BlockImage, Image: parserinterface.Image{URL: attachment.ImageURL}})
+	}
+
+	if attachment.ThumbURL != "" {
+		msg.AddBlock(parserinterface.Block{Type: parserinterface.BlockImage, Image: parserinterface.Image{URL: attachment.ThumbURL}})
+	}
+
+	msg.AddBlock(parserinterface.Block{
+		Type:   parserinterface.BlockContext,
+		Text:   parserinterface.FormatFooter(attachment.Footer, attachment.Timestamp.Time),
+		Format: mrkdwn.FormatPlain,
+	})
+}
+
+// Returns format for attachment's field.
+func (dp DefaultParser) getFormat(attachment slackmessage.SlackAttachments, field string) mrkdwn.Format {
+	if attachment.IsMrkdwn(field) {
+		return mrkdwn.FormatMrkdwn
+	}
 
-	return data
+	return mrkdwn.FormatLinks
 }
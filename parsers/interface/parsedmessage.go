@@ -0,0 +1,238 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package parserinterface
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"go.dev.pztrn.name/opensaps/slack/mrkdwn"
+)
+
+// BlockType is a type of message body block.
+type BlockType int
+
+const (
+	// BlockText is a text in Slack's mrkdwn.
+	BlockText BlockType = iota
+	// BlockHeader is a plain text header, optionally linked to URL.
+	BlockHeader
+	// BlockContext is a secondary text in Slack's mrkdwn, like footers or
+	// authors.
+	BlockContext
+	// BlockFields is a list of fields.
+	BlockFields
+	// BlockImage is an image.
+	BlockImage
+	// BlockDivider separates blocks.
+	BlockDivider
+)
+
+// Severity is a message severity derived from its color.
+type Severity string
+
+const (
+	SeverityNone     Severity = ""
+	SeverityOK       Severity = "ok"
+	SeverityWarning  Severity = "warning"
+	SeverityCritical Severity = "critical"
+)
+
+// Author describes who or what produced message.
+type Author struct {
+	Name string
+	Link string
+	Icon string
+}
+
+// Block is a part of message body. Which fields are used depends on
+// block's type.
+type Block struct {
+	// Text for text, header and context blocks.
+	Text string
+	// URL for header's link.
+	URL string
+	// Image for image blocks.
+	Image Image
+	// Fields for fields blocks.
+	Fields []Field
+	Type   BlockType
+	// Format of text for text and context blocks.
+	Format mrkdwn.Format
+}
+
+// Field is a titled value, like "Status: firing".
+type Field struct {
+	// Title is a plain text.
+	Title string
+	// Value is in Slack's mrkdwn.
+	Value string
+	// Short fields might be displayed side by side.
+	Short bool
+	// Format of value.
+	Format mrkdwn.Format
+}
+
+// Image is an image attached to message.
+type Image struct {
+	URL     string
+	AltText string
+}
+
+// Link is a link found in message.
+type Link struct {
+	URL  string
+	Text string
+}
+
+// ParsedMessage is a message prepared by parser for pushers. Texts are
+// in Slack's mrkdwn unless stated otherwise.
+type ParsedMessage struct {
+	// Timestamp is a time of event message describes. Zero if unknown.
+	Timestamp time.Time
+	Author    Author
+	// Title is a plain text.
+	Title     string
+	TitleLink string
+	// Color is a color from Slack message, like "#36a64f" or "danger".
+	Color    string
+	Severity Severity
+	// Footer is a plain text.
+	Footer string
+	// Blocks is a message body.
+	Blocks []Block
+	Fields []Field
+	Images []Image
+	// Links contains all links found in message's texts.
+	Links []Link
+}
+
+// AddBlock appends block to message body. Text and context blocks with
+// empty text are skipped.
+func (pm *ParsedMessage) AddBlock(block Block) {
+	if (block.Type == BlockText || block.Type == BlockContext) && strings.TrimSpace(block.Text) == "" {
+		return
+	}
+
+	pm.Blocks = append(pm.Blocks, block)
+}
+
+// AddText appends mrkdwn text block to message body if text isn't empty.
+func (pm *ParsedMessage) AddText(text string) {
+	// nolint:exhaustruct
+	pm.AddBlock(Block{Type: BlockText, Text: text})
+}
+
+// CollectLinks adds links found in message's texts and headers to Links.
+func (pm *ParsedMessage) CollectLinks() {
+	var nodes []*mrkdwn.Node
+
+	for _, block := range pm.Blocks {
+		if block.Type == BlockText || block.Type == BlockContext {
+			nodes = append(nodes, mrkdwn.ParseAs(block.Text, block.Format)...)
+		}
+
+		if block.Type == BlockHeader && block.URL != "" {
+			pm.Links = append(pm.Links, Link{URL: block.URL, Text: block.Text})
+		}
+
+		for _, field := range block.Fields {
+			nodes = append(nodes, mrkdwn.ParseAs(field.Value, field.Format)...)
+		}
+	}
+
+	for _, field := range pm.Fields {
+		nodes = append(nodes, mrkdwn.ParseAs(field.Value, field.Format)...)
+	}
+
+	for _, link := range mrkdwn.Links(nodes) {
+		pm.Links = append(pm.Links, Link{URL: link.URL, Text: mrkdwn.PlainText(link.Children)})
+	}
+}
+
+// FooterLine returns message's footer with formatted timestamp.
+func (pm *ParsedMessage) FooterLine() string {
+	return FormatFooter(pm.Footer, pm.Timestamp)
+}
+
+// IsEmpty returns true if there is nothing to send.
+func (pm *ParsedMessage) IsEmpty() bool {
+	return pm.Title == "" && pm.Author.Name == "" && pm.Footer == "" && len(pm.Blocks) == 0 &&
+		len(pm.Fields) == 0 && len(pm.Images) == 0
+}
+
+// FormatFooter returns footer with formatted timestamp (if any), e.g.
+// "Grafana | 2022-01-02 15:04 UTC".
+func FormatFooter(footer string, timestamp time.Time) string {
+	// nolint:gomnd
+	parts := make([]string, 0, 2)
+
+	if footer != "" {
+		parts = append(parts, footer)
+	}
+
+	if !timestamp.IsZero() {
+		parts = append(parts, timestamp.UTC().Format("2006-01-02 15:04 UTC"))
+	}
+
+	return strings.Join(parts, " | ")
+}
+
+// SeverityFromColor figures out severity from Slack color, which might be
+// "good", "warning", "danger" or hex color.
+func SeverityFromColor(color string) Severity {
+	switch strings.ToLower(color) {
+	case "":
+		return SeverityNone
+	case "good":
+		return SeverityOK
+	case "warning":
+		return SeverityWarning
+	case "danger":
+		return SeverityCritical
+	}
+
+	hex := strings.TrimPrefix(color, "#")
+	// nolint:gomnd
+	if len(hex) != 6 {
+		return SeverityNone
+	}
+
+	// nolint:gomnd
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return SeverityNone
+	}
+
+	// nolint:gomnd
+	red, green, blue := (rgb>>16)&0xff, (rgb>>8)&0xff, rgb&0xff
+
+	// nolint:gomnd
+	switch {
+	case green > red && green > blue:
+		return SeverityOK
+	case red > blue && green > blue && green >= red/2:
+		// Yellow and orange.
+		return SeverityWarning
+	case red > green && red > blue:
+		return SeverityCritical
+	default:
+		return SeverityNone
+	}
+}
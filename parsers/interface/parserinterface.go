@@ -23,5 +23,5 @@ import (
 
 type ParserInterface interface {
 	Initialize()
-	ParseMessage(message slackmessage.SlackMessage) map[string]interface{}
+	ParseMessage(message slackmessage.SlackMessage) ParsedMessage
 }
@@ -0,0 +1,116 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package pusherinterface
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+var (
+	// ErrPusherNotFound returns when there is no pusher registered for
+	// requested protocol.
+	ErrPusherNotFound = errors.New("pusher not found")
+	// ErrConnectionNotFound returns when pusher has no connection with
+	// requested name.
+	ErrConnectionNotFound = errors.New("connection not found")
+	// ErrConnectionNotReady returns when connection isn't able to send
+	// messages yet, e.g. when login to remote service wasn't successful.
+	ErrConnectionNotReady = errors.New("connection not ready")
+)
+
+// DeliveryError describes failure occurred while delivering message
+// to remote service.
+type DeliveryError struct {
+	// Err is an underlying error.
+	Err error
+	// Connection is a name of connection used for delivery.
+	Connection string
+	// StatusCode is a HTTP status code remote service replied with.
+	// Zero if no reply was received.
+	StatusCode int
+	// SentMessageID is an ID of partially sent message, e.g. IDs of
+	// long message's parts which were sent before failure. It should be
+	// passed to next attempt, so delivery will continue from first unsent
+	// part. Empty if nothing was sent.
+	SentMessageID string
+	// RetryAfter is a delay remote service asked to wait before next
+	// attempt. Zero if it wasn't set.
+	RetryAfter time.Duration
+	// Temporary shows that delivery might succeed if retried later.
+	Temporary bool
+}
+
+// NewDeliveryError creates delivery error from remote service's reply
+// status code. Network errors (zero status code), rate limiting and
+// server-side errors are considered temporary.
+func NewDeliveryError(connection string, statusCode int, err error) *DeliveryError {
+	return &DeliveryError{
+		Err:           err,
+		Connection:    connection,
+		StatusCode:    statusCode,
+		SentMessageID: "",
+		RetryAfter:    0,
+		Temporary: statusCode == 0 || statusCode == http.StatusTooManyRequests ||
+			statusCode >= http.StatusInternalServerError,
+	}
+}
+
+func (de *DeliveryError) Error() string {
+	return "delivery to connection '" + de.Connection + "' failed: " + de.Err.Error()
+}
+
+func (de *DeliveryError) Unwrap() error {
+	return de.Err
+}
+
+// GetRetryAfter returns delay remote service asked to wait before next
+// delivery attempt, if passed error (or any error it wraps) is a delivery
+// error. Zero is returned otherwise.
+func GetRetryAfter(err error) time.Duration {
+	var deliveryErr *DeliveryError
+	if errors.As(err, &deliveryErr) {
+		return deliveryErr.RetryAfter
+	}
+
+	return 0
+}
+
+// GetSentMessageID returns ID of partially sent message, if passed error
+// (or any error it wraps) is a delivery error. Empty string is returned
+// otherwise.
+func GetSentMessageID(err error) string {
+	var deliveryErr *DeliveryError
+	if errors.As(err, &deliveryErr) {
+		return deliveryErr.SentMessageID
+	}
+
+	return ""
+}
+
+// IsTemporary returns true if passed error (or any error it wraps) is
+// a temporary delivery error.
+func IsTemporary(err error) bool {
+	var deliveryErr *DeliveryError
+	if errors.As(err, &deliveryErr) {
+		return deliveryErr.Temporary
+	}
+
+	return errors.Is(err, ErrConnectionNotReady)
+}
@@ -21,8 +21,35 @@ import (
 	slackmessage "go.dev.pztrn.name/opensaps/slack/message"
 )
 
+// ConnectionStatus describes if connection is able to deliver messages.
+type ConnectionStatus struct {
+	Pusher     string `json:"pusher"`
+	Connection string `json:"connection"`
+	// Error is a reason why connection isn't ready.
+	Error string `json:"error,omitempty"`
+	Ready bool   `json:"ready"`
+}
+
+// PusherInterface is an interface every pusher should implement. Delivery
+// ID passed to methods is same for every attempt to deliver queued
+// message, pushers might use it to avoid duplicates on retries.
 type PusherInterface interface {
+	// Delete deletes previously pushed message.
+	Delete(connection string, messageID string, deliveryID string) error
 	Initialize()
-	Push(connection string, data slackmessage.SlackMessage)
+	// Push sends message and returns ID of message created in remote
+	// service. If previous attempt has sent message partially - sent
+	// message ID from it's delivery error is passed, so pusher could
+	// continue from where it has stopped.
+	Push(connection string, data slackmessage.SlackMessage, deliveryID string, sentMessageID string) (string, error)
+	// Reload applies reloaded configuration: creates new connections,
+	// re-creates changed ones and removes connections which are no
+	// longer configured.
+	Reload()
 	Shutdown()
+	// Status returns statuses of all connections sorted by connection
+	// name.
+	Status() []ConnectionStatus
+	// Update replaces content of previously pushed message.
+	Update(connection string, messageID string, data slackmessage.SlackMessage, deliveryID string) error
 }
@@ -18,13 +18,16 @@
 package matrixpusher
 
 import (
+	"sync"
+
 	"go.dev.pztrn.name/opensaps/context"
 	pusherinterface "go.dev.pztrn.name/opensaps/pushers/interface"
 )
 
 var (
-	ctx         *context.Context
-	connections map[string]*MatrixConnection
+	ctx              *context.Context
+	connections      map[string]*MatrixConnection
+	connectionsMutex sync.RWMutex
 )
 
 func New(cc *context.Context) {
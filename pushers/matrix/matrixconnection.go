@@ -25,11 +25,28 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"strings"
+	"net/url"
+	"sync"
+	"time"
 
+	configstruct "go.dev.pztrn.name/opensaps/config/struct"
+	pusherinterface "go.dev.pztrn.name/opensaps/pushers/interface"
+	"go.dev.pztrn.name/opensaps/pushers/ratelimit"
 	slackmessage "go.dev.pztrn.name/opensaps/slack/message"
 )
 
+const (
+	// Number of attempts to send request before giving up.
+	maxSendAttempts = 3
+	// Maximum delay between attempts to send request. If server asked to
+	// wait longer - delivery queue will retry later.
+	maxRetryDelay = 30 * time.Second
+)
+
+// Returned when server doesn't accept our access token anymore, e.g. when
+// it has expired or was invalidated.
+var errUnknownToken = errors.New("access token was rejected by server")
+
 // Constants for random transaction ID.
 const (
 	letterBytes   = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789" // 36 possibilities
@@ -43,9 +60,34 @@ type MatrixMessage struct {
 	Format  string `json:"format"`
 	// nolint:tagliatelle
 	FormattedBody string `json:"formatted_body"`
+	// NewContent is a content which replaces edited message's content.
+	NewContent *MatrixMessage `json:"m.new_content,omitempty"`
+	// RelatesTo links message to another one, e.g. to edited message.
+	RelatesTo *MatrixRelation `json:"m.relates_to,omitempty"`
+}
+
+// MatrixRelation describes relation between messages.
+// nolint:tagliatelle
+type MatrixRelation struct {
+	// InReplyTo is used by clients without threads support to display
+	// thread's message as reply.
+	InReplyTo *MatrixInReplyTo `json:"m.in_reply_to,omitempty"`
+	RelType   string           `json:"rel_type"`
+	EventID   string           `json:"event_id"`
+	// IsFallingBack shows that InReplyTo is set only for clients without
+	// threads support.
+	IsFallingBack bool `json:"is_falling_back,omitempty"`
+}
+
+// MatrixInReplyTo describes replied message.
+// nolint:tagliatelle
+type MatrixInReplyTo struct {
+	EventID string `json:"event_id"`
 }
 
 type MatrixConnection struct {
+	// Configuration connection was created with.
+	config configstruct.ConfigMatrix
 	// API root for connection.
 	apiRoot string
 	// Connection name.
@@ -58,74 +100,107 @@ type MatrixConnection struct {
 	roomID string
 	// Token we obtained after logging in.
 	token string
+	// Shows that we have joined configured room.
+	joined bool
+	// Last error occurred while logging in or joining room.
+	lastError string
+	// Protects token, joined and lastError.
+	stateMutex sync.RWMutex
+	// Prevents concurrent logins.
+	loginMutex sync.Mutex
+	// Messages that are being sent right now.
+	inFlight sync.WaitGroup
+	// Limits rate of sent messages.
+	limiter *ratelimit.Limiter
 	// Our username for logging in to server.
 	username string
 }
 
-// nolint
 func (mxc *MatrixConnection) doPostRequest(endpoint string, data string) ([]byte, error) {
+	return mxc.doRequest(http.MethodPost, endpoint, data)
+}
+
+func (mxc *MatrixConnection) doPutRequest(endpoint string, data string) ([]byte, error) {
+	return mxc.doRequest(http.MethodPut, endpoint, data)
+}
+
+// Performs request to Matrix API and returns reply's body. If server
+// asked to slow down - returned error will contain requested delay.
+// nolint
+func (mxc *MatrixConnection) doRequest(method string, endpoint string, data string) ([]byte, error) {
 	ctx.Log.Debug().Msgf("Data to send: %+v", data)
 
 	apiRoot := mxc.apiRoot + endpoint
-	if mxc.token != "" {
-		apiRoot += fmt.Sprintf("?access_token=%s", mxc.token)
+
+	token := mxc.getToken()
+	if token != "" {
+		apiRoot += fmt.Sprintf("?access_token=%s", token)
 	}
 
 	ctx.Log.Debug().Msgf("Request URL: %s", apiRoot)
 
-	req, _ := http.NewRequest("POST", apiRoot, bytes.NewBuffer([]byte(data)))
+	req, _ := http.NewRequest(method, apiRoot, bytes.NewBuffer([]byte(data)))
 	req.Header.Set("Content-Type", "application/json")
 
 	client := &http.Client{}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, errors.New("Failed to perform POST request to Matrix as '" +
-			mxc.username + "' (conn " + mxc.connName + "): " + err.Error())
+		return nil, pusherinterface.NewDeliveryError(mxc.connName, 0, errors.New("Failed to perform "+method+
+			" request to Matrix as '"+mxc.username+"': "+err.Error()))
 	}
 
 	defer resp.Body.Close()
 	body, _ := ioutil.ReadAll(resp.Body)
 
-	if resp.Status == "200 OK" {
+	if resp.StatusCode == http.StatusOK {
 		// Return body.
 		return body, nil
 	}
 
-	return nil, errors.New("Status: " + resp.Status + ", body: " + string(body))
-}
+	deliveryErr := pusherinterface.NewDeliveryError(mxc.connName, resp.StatusCode,
+		errors.New("Status: "+resp.Status+", body: "+string(body)))
 
-// nolint
-func (mxc *MatrixConnection) doPutRequest(endpoint string, data string) ([]byte, error) {
-	ctx.Log.Debug().Msgf("Data to send: %+v", data)
+	// nolint:exhaustruct,tagliatelle
+	reply := struct {
+		ErrCode      string `json:"errcode"`
+		RetryAfterMs int64  `json:"retry_after_ms"`
+	}{}
 
-	apiRoot := mxc.apiRoot + endpoint
-	if mxc.token != "" {
-		apiRoot += fmt.Sprintf("?access_token=%s", mxc.token)
+	_ = json.Unmarshal(body, &reply)
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		deliveryErr.RetryAfter = time.Duration(reply.RetryAfterMs) * time.Millisecond
 	}
 
-	ctx.Log.Debug().Msgf("Request URL: %s", apiRoot)
+	// Message can be delivered after logging in again.
+	if token != "" && (resp.StatusCode == http.StatusUnauthorized || reply.ErrCode == "M_UNKNOWN_TOKEN") {
+		mxc.dropToken(token)
 
-	req, _ := http.NewRequest("PUT", apiRoot, bytes.NewBuffer([]byte(data)))
-	req.Header.Set("Content-Type", "application/json")
+		deliveryErr.Err = fmt.Errorf("%w: %s", errUnknownToken, deliveryErr.Err.Error())
+		deliveryErr.Temporary = true
+	}
 
-	client := &http.Client{}
+	return nil, deliveryErr
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, errors.New("Failed to perform PUT request to Matrix as '" +
-			mxc.username + "' (conn " + mxc.connName + "): " + err.Error())
+// Forgets access token rejected by server, so it will be obtained again
+// on next login. Token might be renewed already by concurrent request, so
+// only passed token is forgotten.
+func (mxc *MatrixConnection) dropToken(token string) {
+	mxc.stateMutex.Lock()
+	defer mxc.stateMutex.Unlock()
+
+	if mxc.token != token {
+		return
 	}
 
-	defer resp.Body.Close()
-	body, _ := ioutil.ReadAll(resp.Body)
+	ctx.Log.Warn().Str("conn", mxc.connName).Msg("Access token was rejected by server, will log in again")
 
-	if resp.Status == "200 OK" {
-		// Return body.
-		return body, nil
-	}
+	mxc.token = ""
+	mxc.joined = false
 
-	return nil, errors.New("Status: " + resp.Status + ", body: " + string(body))
+	ctx.Metrics.MatrixLoggedIn(mxc.connName, false)
 }
 
 // This function should be rewritten, I think.
@@ -172,9 +247,67 @@ func (mxc *MatrixConnection) Initialize(connName string, apiRoot string, user st
 	mxc.username = user
 	mxc.password = password
 	mxc.roomID = roomID
-	mxc.token = ""
+}
+
+// Logs in to Matrix server after connection was created. Failed login
+// isn't fatal - we will try to login again when message will be sent.
+func (mxc *MatrixConnection) connect() {
+	if err := mxc.login(); err != nil {
+		ctx.Log.Error().Err(err).Str("conn", mxc.connName).Msg("Failed to initialize connection")
+	}
+}
+
+// Checks if connection was created with different configuration.
+func (mxc *MatrixConnection) isConfigurationChanged(cfg configstruct.ConfigMatrix) bool {
+	return mxc.config != cfg
+}
+
+// Logs in to Matrix server and joins configured room.
+func (mxc *MatrixConnection) login() error {
+	mxc.loginMutex.Lock()
+	defer mxc.loginMutex.Unlock()
+
+	// Someone might already log in while we were waiting for lock.
+	if mxc.isReady() {
+		return nil
+	}
+
+	if mxc.getToken() == "" {
+		if err := mxc.obtainToken(); err != nil {
+			mxc.setLastError(err)
+			ctx.Metrics.MatrixLoggedIn(mxc.connName, false)
+
+			return err
+		}
+	}
+
+	// We should check if we're already in room and, if not, join it.
+	// We will do this by simply trying to join. We don't care about reply
+	// here.
+	_, err := mxc.doPostRequest("/rooms/"+mxc.roomID+"/join", "{}")
+	if err != nil {
+		err = fmt.Errorf("%w: failed to join room '%s': %s", pusherinterface.ErrConnectionNotReady, mxc.roomID, err.Error())
+		mxc.setLastError(err)
+		ctx.Metrics.MatrixLoggedIn(mxc.connName, false)
+
+		return err
+	}
+
+	mxc.stateMutex.Lock()
+	mxc.joined = true
+	mxc.lastError = ""
+	mxc.stateMutex.Unlock()
 
-	ctx.Log.Debug().Str("conn", mxc.connName).Str("api_root", apiRoot).Msg("Trying to connect server")
+	// Connection is reported as logged in only when it's able to send
+	// messages.
+	ctx.Metrics.MatrixLoggedIn(mxc.connName, true)
+
+	return nil
+}
+
+// Logs in to Matrix server and stores obtained access token.
+func (mxc *MatrixConnection) obtainToken() error {
+	ctx.Log.Debug().Str("conn", mxc.connName).Str("api_root", mxc.apiRoot).Msg("Trying to connect server")
 
 	loginStr := fmt.Sprintf(`{"type": "m.login.password", "user": "%s", "password": "%s"}`, mxc.username, mxc.password)
 
@@ -182,7 +315,8 @@ func (mxc *MatrixConnection) Initialize(connName string, apiRoot string, user st
 
 	reply, err := mxc.doPostRequest("/login", loginStr)
 	if err != nil {
-		ctx.Log.Fatal().Msgf("Failed to login to Matrix with user '%s' (conn %s): '%s'", mxc.username, mxc.connName, err.Error())
+		return fmt.Errorf("%w: failed to login with user '%s': %s", pusherinterface.ErrConnectionNotReady,
+			mxc.username, err.Error())
 	}
 
 	// Parse received JSON and get access token.
@@ -190,80 +324,263 @@ func (mxc *MatrixConnection) Initialize(connName string, apiRoot string, user st
 
 	err1 := json.Unmarshal(reply, &data)
 	if err1 != nil {
-		ctx.Log.Fatal().
-			Str("username", mxc.username).
-			Str("conn", mxc.connName).
-			Err(err).
-			Msgf("Failed to parse received JSON from Matrix: %s)", reply)
+		return fmt.Errorf("%w: failed to parse received JSON from Matrix (%s): %s", pusherinterface.ErrConnectionNotReady,
+			reply, err1.Error())
 	}
 
-	mxc.token, _ = data["access_token"].(string)
+	token, _ := data["access_token"].(string)
 	mxc.deviceID, _ = data["deviceID"].(string)
 
-	ctx.Log.Debug().Str("conn", mxc.connName).Str("access_token", mxc.token).Str("device_id", mxc.deviceID).Msg("Login successful")
+	ctx.RegisterSecret(token)
 
-	// We should check if we're already in room and, if not, join it.
-	// We will do this by simply trying to join. We don't care about reply
-	// here.
-	_, err2 := mxc.doPostRequest("/rooms/"+mxc.roomID+"/join", "{}")
-	if err2 != nil {
-		ctx.Log.Fatal().Err(err).Msg("Failed to join room")
+	mxc.stateMutex.Lock()
+	mxc.token = token
+	mxc.stateMutex.Unlock()
+
+	ctx.Log.Debug().Str("conn", mxc.connName).Str("access_token", token).Str("device_id", mxc.deviceID).Msg("Login successful")
+
+	return nil
+}
+
+// Returns true if we're logged in and joined configured room.
+func (mxc *MatrixConnection) isReady() bool {
+	mxc.stateMutex.RLock()
+	defer mxc.stateMutex.RUnlock()
+
+	return mxc.token != "" && mxc.joined
+}
+
+func (mxc *MatrixConnection) setLastError(err error) {
+	mxc.stateMutex.Lock()
+	mxc.lastError = ctx.Redact(err.Error())
+	mxc.stateMutex.Unlock()
+}
+
+// Status returns connection's status. Connection name should be filled
+// by caller.
+func (mxc *MatrixConnection) Status() pusherinterface.ConnectionStatus {
+	mxc.stateMutex.RLock()
+	defer mxc.stateMutex.RUnlock()
+
+	// nolint:exhaustruct
+	status := pusherinterface.ConnectionStatus{
+		Pusher: "matrix",
+		Error:  mxc.lastError,
+		Ready:  mxc.token != "" && mxc.joined,
 	}
+
+	if !status.Ready && status.Error == "" {
+		status.Error = "not logged in yet"
+	}
+
+	return status
+}
+
+// Returns access token obtained after logging in.
+func (mxc *MatrixConnection) getToken() string {
+	mxc.stateMutex.RLock()
+	defer mxc.stateMutex.RUnlock()
+
+	return mxc.token
 }
 
 // This function launches when new data was received thru Slack API.
 // It will prepare a message which will be passed to mxc.SendMessage().
-func (mxc *MatrixConnection) ProcessMessage(message slackmessage.SlackMessage) {
+// If thread root event ID is passed, message will be sent into that
+// thread. Returns ID of sent event.
+func (mxc *MatrixConnection) ProcessMessage(message slackmessage.SlackMessage, threadRoot string,
+	txnID string) (string, error) {
 	// Prepare message body.
 	messageData := ctx.SendToParser(message.Username, message)
 
-	messageToSend, _ := messageData["message"].(string)
-	// We'll use HTML, so reformat links accordingly (if any).
-	linksRaw, linksFound := messageData["links"]
-	if linksFound {
-		links, _ := linksRaw.([][]string)
-		for _, link := range links {
-			messageToSend = strings.ReplaceAll(messageToSend, link[0], `<a href="`+link[1]+`">`+link[2]+`</a>`)
-		}
+	if messageData.IsEmpty() {
+		ctx.Log.Debug().Str("conn", mxc.connName).Msg("Parsed message is empty, nothing to send")
+
+		return "", nil
 	}
 
-	// "\n" should be "<br>".
-	messageToSend = strings.ReplaceAll(messageToSend, "\n", "<br>")
+	// Plain text version is sent for clients which can't display HTML.
+	plainMessage, formattedMessage := renderMessage(messageData)
 
-	ctx.Log.Debug().Msgf("Crafted message: %s", messageToSend)
+	ctx.Log.Debug().Msgf("Crafted message: %s", formattedMessage)
 
 	// Send message.
-	mxc.SendMessage(messageToSend)
+	return mxc.SendMessage(plainMessage, formattedMessage, threadRoot, txnID)
+}
+
+// Same as ProcessMessage, but edits previously sent event.
+func (mxc *MatrixConnection) ProcessUpdate(eventID string, message slackmessage.SlackMessage, txnID string) error {
+	messageData := ctx.SendToParser(message.Username, message)
+
+	if messageData.IsEmpty() {
+		ctx.Log.Debug().Str("conn", mxc.connName).Msg("Parsed message is empty, nothing to update")
+
+		return nil
+	}
+
+	plainMessage, formattedMessage := renderMessage(messageData)
+
+	return mxc.EditMessage(eventID, plainMessage, formattedMessage, txnID)
 }
 
-// This function sends already prepared message to room.
-func (mxc *MatrixConnection) SendMessage(message string) {
-	ctx.Log.Debug().Str("conn", mxc.connName).Msgf("Sending message: '%s'", message)
+// EditMessage replaces content of previously sent event.
+func (mxc *MatrixConnection) EditMessage(eventID string, message string, formattedMessage string, txnID string) error {
+	ctx.Log.Debug().Str("conn", mxc.connName).Str("event_id", eventID).Msgf("Editing message: '%s'", formattedMessage)
 
+	newContent := mxc.newMessage(message, formattedMessage)
+
+	// Clients which doesn't support edits will display message with
+	// asterisk, as it is usually done in chats.
+	msg := mxc.newMessage("* "+message, "* "+formattedMessage)
+	msg.NewContent = &newContent
+	// nolint:exhaustruct
+	msg.RelatesTo = &MatrixRelation{RelType: "m.replace", EventID: eventID}
+
+	_, err := mxc.sendEvent(msg, txnID)
+
+	return err
+}
+
+// RedactMessage deletes previously sent event.
+func (mxc *MatrixConnection) RedactMessage(eventID string, txnID string) error {
+	ctx.Log.Debug().Str("conn", mxc.connName).Str("event_id", eventID).Msg("Redacting message")
+
+	if err := mxc.ensureReady(); err != nil {
+		return err
+	}
+
+	_, err := mxc.doRateLimitedPut("/rooms/"+mxc.roomID+"/redact/"+url.PathEscape(eventID)+"/", "{}", txnID)
+
+	return err
+}
+
+// Performs PUT request to endpoint which requires transaction ID, like
+// sending events. Request is delayed if connection sends too many
+// messages and is retried on temporary errors (including rate limiting)
+// with same transaction ID, so server won't process it twice. Delivery
+// queue passes same transaction ID for every attempt to deliver message,
+// new one is generated if it's empty.
+func (mxc *MatrixConnection) doRateLimitedPut(endpoint string, data string, txnID string) ([]byte, error) {
+	if txnID == "" {
+		txnID = mxc.generateTnxID()
+	}
+
+	for attempt := 1; ; attempt++ {
+		mxc.limiter.Wait()
+
+		reply, err := mxc.doPutRequest(endpoint+txnID, data)
+		if err == nil {
+			return reply, nil
+		}
+
+		if errors.Is(err, errUnknownToken) && attempt < maxSendAttempts {
+			if err := mxc.login(); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		delay, retry := mxc.getRetryDelay(err, attempt)
+		if !retry {
+			return nil, err
+		}
+
+		ctx.Log.Warn().Err(err).Str("conn", mxc.connName).Int("attempt", attempt).Dur("delay", delay).
+			Msg("Failed to send request to Matrix, will retry")
+
+		// Other messages should wait too.
+		mxc.limiter.Pause(delay)
+	}
+}
+
+// Returns delay before next attempt to send request and whether it
+// should be retried at all. Long delays are left to delivery queue.
+func (mxc *MatrixConnection) getRetryDelay(err error, attempt int) (time.Duration, bool) {
+	if !pusherinterface.IsTemporary(err) || attempt >= maxSendAttempts {
+		return 0, false
+	}
+
+	delay := time.Duration(attempt) * time.Second
+	if retryAfter := pusherinterface.GetRetryAfter(err); retryAfter > 0 {
+		delay = retryAfter
+	}
+
+	return delay, delay <= maxRetryDelay
+}
+
+// This function sends already prepared message to room, into thread if
+// it's root event ID is passed. Returns ID of sent event.
+func (mxc *MatrixConnection) SendMessage(message string, formattedMessage string, threadRoot string,
+	txnID string) (string, error) {
+	ctx.Log.Debug().Str("conn", mxc.connName).Msgf("Sending message: '%s'", formattedMessage)
+
+	msg := mxc.newMessage(message, formattedMessage)
+
+	if threadRoot != "" {
+		msg.RelatesTo = &MatrixRelation{
+			InReplyTo:     &MatrixInReplyTo{EventID: threadRoot},
+			RelType:       "m.thread",
+			EventID:       threadRoot,
+			IsFallingBack: true,
+		}
+	}
+
+	return mxc.sendEvent(msg, txnID)
+}
+
+// Previous login attempt might fail, so try again before sending.
+func (mxc *MatrixConnection) ensureReady() error {
+	if mxc.isReady() {
+		return nil
+	}
+
+	return mxc.login()
+}
+
+func (mxc *MatrixConnection) newMessage(message string, formattedMessage string) MatrixMessage {
 	// We should send notices as it is preferred behavior for bots and
-	// appservices.
-	msg := MatrixMessage{
+	// appservices. Formatted message is sanitized in case something
+	// (e.g. parser) produced HTML which clients shouldn't render.
+	// nolint:exhaustruct
+	return MatrixMessage{
 		MsgType:       "m.notice",
 		Body:          message,
 		Format:        "org.matrix.custom.html",
-		FormattedBody: message,
+		FormattedBody: sanitizeHTML(formattedMessage),
+	}
+}
+
+// Sends message event to room and returns it's ID.
+func (mxc *MatrixConnection) sendEvent(msg MatrixMessage, txnID string) (string, error) {
+	if err := mxc.ensureReady(); err != nil {
+		return "", err
 	}
 
 	msgBytes, err := json.Marshal(&msg)
 	if err != nil {
-		ctx.Log.Error().Err(err).Msg("Failed to marshal message into JSON.")
-
-		return
+		return "", fmt.Errorf("failed to marshal message into JSON: %w", err)
 	}
 
-	msgStr := string(msgBytes)
-
-	reply, err := mxc.doPutRequest("/rooms/"+mxc.roomID+"/send/m.room.message/"+mxc.generateTnxID(), msgStr)
+	reply, err := mxc.doRateLimitedPut("/rooms/"+mxc.roomID+"/send/m.room.message/", string(msgBytes), txnID)
 	if err != nil {
-		ctx.Log.Fatal().Str("conn", mxc.connName).Str("room", mxc.roomID).Err(err).Msg("Failed to send message to room")
+		return "", err
 	}
 
 	ctx.Log.Debug().Msgf("Message sent, reply: %s", string(reply))
+
+	// nolint:exhaustruct,tagliatelle
+	data := struct {
+		EventID string `json:"event_id"`
+	}{}
+
+	// Message was sent anyway, so failed decoding shouldn't be reported
+	// as delivery error.
+	if err := json.Unmarshal(reply, &data); err != nil {
+		ctx.Log.Warn().Err(err).Str("conn", mxc.connName).Msg("Failed to decode event ID from Matrix reply")
+	}
+
+	return data.EventID, nil
 }
 
 func (mxc *MatrixConnection) Shutdown() {
@@ -274,6 +591,10 @@ func (mxc *MatrixConnection) Shutdown() {
 		ctx.Log.Error().Err(err).Str("conn", mxc.connName).Msg("Error occurred while trying to log out from Matrix.")
 	}
 
+	mxc.stateMutex.Lock()
 	mxc.token = ""
+	mxc.joined = false
+	mxc.stateMutex.Unlock()
+
 	ctx.Log.Info().Str("conn", mxc.connName).Msg("Connection successfully shutted down")
 }
@@ -17,7 +17,15 @@
 // along with this program.  If not, see <http://www.gnu.org/licenses/>.
 package matrixpusher
 
-import slackmessage "go.dev.pztrn.name/opensaps/slack/message"
+import (
+	"fmt"
+	"sort"
+
+	configstruct "go.dev.pztrn.name/opensaps/config/struct"
+	pusherinterface "go.dev.pztrn.name/opensaps/pushers/interface"
+	"go.dev.pztrn.name/opensaps/pushers/ratelimit"
+	slackmessage "go.dev.pztrn.name/opensaps/slack/message"
+)
 
 type MatrixPusher struct{}
 
@@ -26,34 +34,168 @@ func (mp MatrixPusher) Initialize() {
 
 	// Get configuration for pushers and initialize every connection.
 	cfg := ctx.Config.GetConfig()
-	for name, config := range cfg.Matrix {
-		ctx.Log.Info().Str("conn", name).Msg("Initializing connection...")
 
-		// Fields will be filled with conn.Initialize().
-		// nolint:exhaustruct
-		conn := MatrixConnection{}
-		connections[name] = &conn
+	connectionsMutex.Lock()
+	defer connectionsMutex.Unlock()
 
-		go conn.Initialize(name, config.APIRoot, config.User, config.Password, config.Room)
+	for name, config := range cfg.Matrix {
+		mp.startConnection(name, config)
 	}
 }
 
-func (mp MatrixPusher) Push(connection string, data slackmessage.SlackMessage) {
-	conn, found := connections[connection]
-	if !found {
-		ctx.Log.Error().Str("conn", connection).Msg("Connection not found!")
+func (mp MatrixPusher) Delete(connection string, messageID string, deliveryID string) error {
+	conn, err := mp.acquireConnection(connection)
+	if err != nil {
+		return err
+	}
+	defer conn.inFlight.Done()
 
-		return
+	return conn.RedactMessage(messageID, deliveryID)
+}
+
+// Events are always sent as a whole, so there is nothing to continue.
+func (mp MatrixPusher) Push(connection string, data slackmessage.SlackMessage, deliveryID string,
+	_ string) (string, error) {
+	conn, err := mp.acquireConnection(connection)
+	if err != nil {
+		return "", err
 	}
+	defer conn.inFlight.Done()
 
 	ctx.Log.Debug().Str("conn", connection).Msg("Pushing data to connection")
-	conn.ProcessMessage(data)
+
+	return conn.ProcessMessage(data, mp.getThreadRoot(connection, data), deliveryID)
+}
+
+func (mp MatrixPusher) Reload() {
+	ctx.Log.Info().Msg("Reloading Matrix protocol pusher...")
+
+	cfg := ctx.Config.GetConfig()
+
+	connectionsMutex.Lock()
+	defer connectionsMutex.Unlock()
+
+	for name, conn := range connections {
+		config, found := cfg.Matrix[name]
+
+		switch {
+		case !found:
+			ctx.Log.Info().Str("conn", name).Msg("Connection was removed from configuration, shutting it down")
+			ctx.Metrics.MatrixLoggedIn(name, false)
+		case conn.isConfigurationChanged(config):
+			ctx.Log.Info().Str("conn", name).Msg("Connection configuration was changed, re-creating it")
+		default:
+			continue
+		}
+
+		delete(connections, name)
+
+		// Let messages that are being sent right now to be delivered
+		// before logging out.
+		go func(conn *MatrixConnection) {
+			conn.inFlight.Wait()
+			conn.Shutdown()
+		}(conn)
+	}
+
+	for name, config := range cfg.Matrix {
+		if _, found := connections[name]; !found {
+			mp.startConnection(name, config)
+		}
+	}
 }
 
 func (mp MatrixPusher) Shutdown() {
 	ctx.Log.Info().Msg("Shutting down Matrix pusher...")
 
-	for _, conn := range connections {
+	connectionsMutex.RLock()
+	defer connectionsMutex.RUnlock()
+
+	for name, conn := range connections {
 		conn.Shutdown()
+		ctx.Metrics.MatrixLoggedIn(name, false)
+	}
+}
+
+func (mp MatrixPusher) Status() []pusherinterface.ConnectionStatus {
+	connectionsMutex.RLock()
+	defer connectionsMutex.RUnlock()
+
+	statuses := make([]pusherinterface.ConnectionStatus, 0, len(connections))
+
+	for name, conn := range connections {
+		status := conn.Status()
+		status.Connection = name
+		statuses = append(statuses, status)
 	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Connection < statuses[j].Connection })
+
+	return statuses
+}
+
+func (mp MatrixPusher) Update(connection string, messageID string, data slackmessage.SlackMessage,
+	deliveryID string) error {
+	conn, err := mp.acquireConnection(connection)
+	if err != nil {
+		return err
+	}
+	defer conn.inFlight.Done()
+
+	return conn.ProcessUpdate(messageID, data, deliveryID)
+}
+
+// Returns ID of event which passed message replies to. Empty string is
+// returned if message isn't a reply or if replied message wasn't sent by
+// this connection.
+func (mp MatrixPusher) getThreadRoot(connection string, data slackmessage.SlackMessage) string {
+	if data.ThreadTS == "" {
+		return ""
+	}
+
+	entry, found := ctx.MessageMap.Get(data.ThreadTS)
+	if !found || entry.MessageID("matrix", connection) == "" {
+		ctx.Log.Debug().Str("conn", connection).Str("thread_ts", data.ThreadTS).
+			Msg("Thread's message wasn't sent by connection, reply will be sent as usual message")
+
+		return ""
+	}
+
+	return entry.MessageID("matrix", connection)
+}
+
+// Returns connection with passed name and marks it as used, so it won't
+// be shutted down on reload until conn.inFlight.Done() will be called.
+func (mp MatrixPusher) acquireConnection(connection string) (*MatrixConnection, error) {
+	connectionsMutex.RLock()
+	defer connectionsMutex.RUnlock()
+
+	conn, found := connections[connection]
+	if !found {
+		ctx.Log.Error().Str("conn", connection).Msg("Connection not found!")
+
+		return nil, fmt.Errorf("%w: %s", pusherinterface.ErrConnectionNotFound, connection)
+	}
+
+	conn.inFlight.Add(1)
+
+	return conn, nil
+}
+
+// Creates connection and logs in in background. Should be called with
+// connections mutex locked.
+func (mp MatrixPusher) startConnection(name string, config configstruct.ConfigMatrix) {
+	ctx.Log.Info().Str("conn", name).Msg("Initializing connection...")
+
+	// Other fields will be filled with conn.Initialize(). Connection
+	// should be initialized before it's published, as messages might be
+	// pushed right away.
+	// nolint:exhaustruct
+	conn := &MatrixConnection{config: config, limiter: ratelimit.New(config.GetRateLimit(), config.GetRateBurst())}
+	conn.Initialize(name, config.APIRoot, config.User, config.Password, config.Room)
+	connections[name] = conn
+
+	ctx.Metrics.MatrixLoggedIn(name, false)
+
+	go conn.connect()
 }
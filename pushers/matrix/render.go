@@ -0,0 +1,225 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package matrixpusher
+
+import (
+	"html"
+	"strings"
+
+	parserinterface "go.dev.pztrn.name/opensaps/parsers/interface"
+	"go.dev.pztrn.name/opensaps/slack/mrkdwn"
+)
+
+// Renders mrkdwn nodes as HTML suitable for message's formatted_body.
+func renderHTML(nodes []*mrkdwn.Node) string {
+	var builder strings.Builder
+
+	writeHTML(&builder, nodes)
+
+	return builder.String()
+}
+
+func writeHTML(builder *strings.Builder, nodes []*mrkdwn.Node) {
+	for _, node := range nodes {
+		switch node.Type {
+		case mrkdwn.NodeText, mrkdwn.NodeMention:
+			builder.WriteString(html.EscapeString(node.Text))
+		case mrkdwn.NodeBold:
+			writeHTMLTag(builder, "strong", node.Children)
+		case mrkdwn.NodeItalic:
+			writeHTMLTag(builder, "em", node.Children)
+		case mrkdwn.NodeStrike:
+			writeHTMLTag(builder, "del", node.Children)
+		case mrkdwn.NodeCode:
+			builder.WriteString("<code>" + html.EscapeString(node.Text) + "</code>")
+		case mrkdwn.NodeCodeBlock:
+			builder.WriteString("<pre><code>" + html.EscapeString(node.Text) + "</code></pre>")
+		case mrkdwn.NodeQuote:
+			writeHTMLTag(builder, "blockquote", node.Children)
+		case mrkdwn.NodeLink:
+			// Unsafe links are displayed as labels only.
+			if !mrkdwn.IsSafeURL(node.URL) {
+				writeHTML(builder, node.Children)
+
+				break
+			}
+
+			builder.WriteString(`<a href="` + html.EscapeString(node.URL) + `">`)
+			writeHTML(builder, node.Children)
+			builder.WriteString("</a>")
+		case mrkdwn.NodeLineBreak:
+			builder.WriteString("<br>")
+		}
+	}
+}
+
+func writeHTMLTag(builder *strings.Builder, tag string, children []*mrkdwn.Node) {
+	builder.WriteString("<" + tag + ">")
+	writeHTML(builder, children)
+	builder.WriteString("</" + tag + ">")
+}
+
+// Message rendered for Matrix: plain text body for clients which can't
+// display HTML and HTML formatted body.
+type renderedMessage struct {
+	plain strings.Builder
+	html  strings.Builder
+}
+
+// Renders parsed message. Returns plain text and HTML versions.
+func renderMessage(msg parserinterface.ParsedMessage) (string, string) {
+	// nolint:exhaustruct
+	rm := &renderedMessage{}
+
+	if msg.Title != "" {
+		rm.addLine(plainLink(msg.Title, msg.TitleLink), "<strong>"+htmlLink(msg.Title, msg.TitleLink)+"</strong>")
+	}
+
+	if msg.Author.Name != "" {
+		rm.addLine(msg.Author.Name, "<em>"+htmlLink(msg.Author.Name, msg.Author.Link)+"</em>")
+	}
+
+	for _, block := range msg.Blocks {
+		rm.addBlock(block)
+	}
+
+	rm.addFields(msg.Fields)
+
+	for _, image := range msg.Images {
+		rm.addImage(image)
+	}
+
+	if footer := msg.FooterLine(); footer != "" {
+		rm.addLine(footer, "<em>"+html.EscapeString(footer)+"</em>")
+	}
+
+	return rm.plain.String(), rm.html.String()
+}
+
+func (rm *renderedMessage) addBlock(block parserinterface.Block) {
+	switch block.Type {
+	case parserinterface.BlockText:
+		nodes := mrkdwn.ParseAs(block.Text, block.Format)
+		rm.addLine(mrkdwn.PlainText(nodes), renderHTML(nodes))
+	case parserinterface.BlockHeader:
+		rm.addLine(plainLink(block.Text, block.URL), "<strong>"+htmlLink(block.Text, block.URL)+"</strong>")
+	case parserinterface.BlockContext:
+		nodes := mrkdwn.ParseAs(block.Text, block.Format)
+		rm.addLine(mrkdwn.PlainText(nodes), "<em>"+renderHTML(nodes)+"</em>")
+	case parserinterface.BlockFields:
+		rm.addFields(block.Fields)
+	case parserinterface.BlockImage:
+		rm.addImage(block.Image)
+	case parserinterface.BlockDivider:
+		rm.addLine("---", "<hr>")
+	}
+}
+
+// Adds fields as table with titles in first column.
+func (rm *renderedMessage) addFields(fields []parserinterface.Field) {
+	if len(fields) == 0 {
+		return
+	}
+
+	plain := make([]string, 0, len(fields))
+
+	var table strings.Builder
+
+	table.WriteString("<table>")
+
+	for _, field := range fields {
+		nodes := mrkdwn.ParseAs(field.Value, field.Format)
+
+		// Block Kit fields have no titles.
+		if field.Title == "" {
+			plain = append(plain, mrkdwn.PlainText(nodes))
+			table.WriteString("<tr><td>" + renderHTML(nodes) + "</td></tr>")
+
+			continue
+		}
+
+		plain = append(plain, field.Title+": "+mrkdwn.PlainText(nodes))
+		table.WriteString("<tr><th>" + html.EscapeString(field.Title) + "</th><td>" + renderHTML(nodes) + "</td></tr>")
+	}
+
+	table.WriteString("</table>")
+
+	rm.addLine(strings.Join(plain, "\n"), table.String())
+}
+
+func (rm *renderedMessage) addImage(image parserinterface.Image) {
+	label := image.AltText
+	if label == "" {
+		label = image.URL
+	}
+
+	rm.addLine(plainLink(label, image.URL), htmlLink(label, image.URL))
+}
+
+// Adds line to both versions of message.
+func (rm *renderedMessage) addLine(plain string, formatted string) {
+	if rm.plain.Len() != 0 {
+		rm.plain.WriteString("\n")
+
+		// Block elements are displayed on separate lines anyway.
+		if !hasBlockEnd(rm.html.String()) && !hasBlockStart(formatted) {
+			rm.html.WriteString("<br>")
+		}
+	}
+
+	rm.plain.WriteString(plain)
+	rm.html.WriteString(formatted)
+}
+
+// Returns link to URL with passed plain text label. If URL is empty or
+// unsafe - only label is returned.
+func htmlLink(label string, url string) string {
+	if url == "" || !mrkdwn.IsSafeURL(url) {
+		return html.EscapeString(label)
+	}
+
+	return `<a href="` + html.EscapeString(url) + `">` + html.EscapeString(label) + "</a>"
+}
+
+func plainLink(label string, url string) string {
+	if url == "" || url == label {
+		return label
+	}
+
+	return label + " (" + url + ")"
+}
+
+func hasBlockStart(formatted string) bool {
+	for _, tag := range []string{"<blockquote>", "<hr>", "<pre>", "<table>"} {
+		if strings.HasPrefix(formatted, tag) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasBlockEnd(formatted string) bool {
+	for _, tag := range []string{"</blockquote>", "<hr>", "</pre>", "</table>"} {
+		if strings.HasSuffix(formatted, tag) {
+			return true
+		}
+	}
+
+	return false
+}
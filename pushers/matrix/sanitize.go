@@ -0,0 +1,298 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package matrixpusher
+
+import (
+	"html"
+	"regexp"
+	"strings"
+
+	"go.dev.pztrn.name/opensaps/slack/mrkdwn"
+)
+
+// nolint:gochecknoglobals
+var (
+	// Tags allowed in formatted_body with their allowed attributes. This
+	// is a list recommended by Matrix specification.
+	allowedTags = map[string][]string{
+		"a": {"href"}, "b": nil, "blockquote": nil, "br": nil, "caption": nil, "code": {"class"},
+		"del": nil, "details": nil, "div": nil, "em": nil, "font": {"color", "data-mx-bg-color", "data-mx-color"},
+		"h1": nil, "h2": nil, "h3": nil, "h4": nil, "h5": nil, "h6": nil, "hr": nil, "i": nil,
+		"img": {"alt", "height", "src", "title", "width"}, "li": nil, "ol": {"start"}, "p": nil, "pre": nil,
+		"s": nil, "span": {"data-mx-bg-color", "data-mx-color", "data-mx-spoiler"}, "strong": nil, "sub": nil,
+		"summary": nil, "sup": nil, "table": nil, "tbody": nil, "td": nil, "th": nil, "thead": nil, "tr": nil,
+		"u": nil, "ul": nil,
+	}
+	// Tags which are dropped with their content.
+	droppedTags = map[string]bool{"iframe": true, "script": true, "style": true}
+	// Tags which have no content and closing tag.
+	voidTags = map[string]bool{"br": true, "hr": true, "img": true}
+
+	colorRegexp  = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+	entityRegexp = regexp.MustCompile(`^&(#[0-9]{1,7}|#[xX][0-9a-fA-F]{1,6}|[a-zA-Z][a-zA-Z0-9]{1,31});`)
+	numberRegexp = regexp.MustCompile(`^[0-9]{1,9}$`)
+)
+
+// HTML tag found in text.
+type htmlTag struct {
+	attributes map[string]string
+	name       string
+	// Whole tag, including "<" and ">".
+	raw     string
+	closing bool
+}
+
+// Sanitizes HTML for message's formatted_body, so only tags and
+// attributes allowed by Matrix specification will be sent. Disallowed
+// tags are removed (their content is kept as text), stray "<", ">" and
+// "&" are escaped and unclosed tags are closed.
+func sanitizeHTML(text string) string {
+	var (
+		builder strings.Builder
+		open    []string
+		// Name of tag which content is being dropped.
+		dropping string
+	)
+
+	for position := 0; position < len(text); {
+		if text[position] == '<' {
+			if tag, found := parseTag(text[position:]); found {
+				position += len(tag.raw)
+
+				switch {
+				case dropping != "":
+					if tag.closing && tag.name == dropping {
+						dropping = ""
+					}
+				case droppedTags[tag.name]:
+					if !tag.closing {
+						dropping = tag.name
+					}
+				case tag.closing:
+					open = closeSanitizedTag(&builder, open, tag.name)
+				default:
+					if writeSanitizedTag(&builder, tag) && !voidTags[tag.name] {
+						open = append(open, tag.name)
+					}
+				}
+
+				continue
+			}
+		}
+
+		char := text[position]
+		position++
+
+		if dropping != "" {
+			continue
+		}
+
+		switch char {
+		case '<':
+			builder.WriteString("&lt;")
+		case '>':
+			builder.WriteString("&gt;")
+		case '&':
+			if entity := entityRegexp.FindString(text[position-1:]); entity != "" {
+				builder.WriteString(entity)
+
+				position += len(entity) - 1
+			} else {
+				builder.WriteString("&amp;")
+			}
+		default:
+			builder.WriteByte(char)
+		}
+	}
+
+	for i := len(open) - 1; i >= 0; i-- {
+		builder.WriteString("</" + open[i] + ">")
+	}
+
+	return builder.String()
+}
+
+// Closes tag with passed name and every tag opened after it. Closing tags
+// for tags which aren't open are dropped.
+func closeSanitizedTag(builder *strings.Builder, open []string, name string) []string {
+	for i := len(open) - 1; i >= 0; i-- {
+		if open[i] != name {
+			continue
+		}
+
+		for j := len(open) - 1; j >= i; j-- {
+			builder.WriteString("</" + open[j] + ">")
+		}
+
+		return open[:i]
+	}
+
+	return open
+}
+
+// Writes tag with allowed attributes only. Returns false if tag isn't
+// allowed.
+func writeSanitizedTag(builder *strings.Builder, tag htmlTag) bool {
+	attributes, allowed := allowedTags[tag.name]
+	if !allowed {
+		return false
+	}
+
+	// Images are allowed only from Matrix content repository.
+	if tag.name == "img" && !strings.HasPrefix(tag.attributes["src"], "mxc://") {
+		return false
+	}
+
+	builder.WriteString("<" + tag.name)
+
+	for _, attribute := range attributes {
+		value, found := tag.attributes[attribute]
+		if !found || !isAllowedAttributeValue(attribute, value) {
+			continue
+		}
+
+		builder.WriteString(" " + attribute + `="` + html.EscapeString(value) + `"`)
+	}
+
+	builder.WriteString(">")
+
+	return true
+}
+
+func isAllowedAttributeValue(attribute string, value string) bool {
+	switch attribute {
+	case "href":
+		return mrkdwn.IsSafeURL(value)
+	case "class":
+		return strings.HasPrefix(value, "language-") && !strings.ContainsAny(value, " \t\n")
+	case "color", "data-mx-bg-color", "data-mx-color":
+		return colorRegexp.MatchString(value)
+	case "height", "start", "width":
+		return numberRegexp.MatchString(value)
+	default:
+		return true
+	}
+}
+
+// Parses tag text starts with. Returns false if text doesn't start with
+// a tag, e.g. "a < b".
+// nolint:cyclop
+func parseTag(text string) (htmlTag, bool) {
+	// nolint:exhaustruct
+	tag := htmlTag{attributes: make(map[string]string)}
+
+	position := 1
+	if position < len(text) && text[position] == '/' {
+		tag.closing = true
+		position++
+	}
+
+	nameStart := position
+	for position < len(text) && isTagNameByte(text[position]) {
+		position++
+	}
+
+	if position == nameStart || !isLetter(text[nameStart]) {
+		return tag, false
+	}
+
+	tag.name = strings.ToLower(text[nameStart:position])
+
+	for position < len(text) {
+		switch char := text[position]; {
+		case char == '>':
+			tag.raw = text[:position+1]
+
+			return tag, true
+		case char == '/' || isSpaceByte(char):
+			position++
+		default:
+			var name, value string
+
+			name, value, position = parseAttribute(text, position)
+			if _, found := tag.attributes[name]; !found {
+				tag.attributes[name] = value
+			}
+		}
+	}
+
+	// Tag isn't closed.
+	return tag, false
+}
+
+// Parses attribute starting at passed position and returns it's name,
+// decoded value and position after it.
+func parseAttribute(text string, position int) (string, string, int) {
+	nameStart := position
+	for position < len(text) && !isSpaceByte(text[position]) && !strings.ContainsRune("=>/", rune(text[position])) {
+		position++
+	}
+
+	// Lone "=" is treated as attribute without name.
+	if position == nameStart {
+		position++
+	}
+
+	name := strings.ToLower(text[nameStart:position])
+
+	for position < len(text) && isSpaceByte(text[position]) {
+		position++
+	}
+
+	if position >= len(text) || text[position] != '=' {
+		return name, "", position
+	}
+
+	position++
+
+	for position < len(text) && isSpaceByte(text[position]) {
+		position++
+	}
+
+	if position >= len(text) {
+		return name, "", position
+	}
+
+	if quote := text[position]; quote == '"' || quote == '\'' {
+		end := strings.IndexByte(text[position+1:], quote)
+		if end == -1 {
+			return name, "", len(text)
+		}
+
+		return name, html.UnescapeString(text[position+1 : position+1+end]), position + end + 2
+	}
+
+	valueStart := position
+	for position < len(text) && !isSpaceByte(text[position]) && text[position] != '>' {
+		position++
+	}
+
+	return name, html.UnescapeString(text[valueStart:position]), position
+}
+
+func isLetter(char byte) bool {
+	return (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z')
+}
+
+func isSpaceByte(char byte) bool {
+	return char == ' ' || char == '\t' || char == '\n' || char == '\r' || char == '\f'
+}
+
+func isTagNameByte(char byte) bool {
+	return isLetter(char) || (char >= '0' && char <= '9')
+}
@@ -0,0 +1,131 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package matrixpusher
+
+import (
+	"testing"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "allowed tags are kept",
+			text: "<b>bold</b> <i>italic</i><br><pre><code>code</code></pre>",
+			want: "<b>bold</b> <i>italic</i><br><pre><code>code</code></pre>",
+		},
+		{
+			name: "tag names are lowercased",
+			text: "<B>bold</B>",
+			want: "<b>bold</b>",
+		},
+		{
+			name: "disallowed tags are removed",
+			text: "<marquee>text</marquee>",
+			want: "text",
+		},
+		{
+			name: "scripts are dropped with content",
+			text: "a<script>alert('<b>')</script>b<style>b { }</style>c",
+			want: "abc",
+		},
+		{
+			name: "unclosed script drops rest of text",
+			text: "a<script>alert(1)",
+			want: "a",
+		},
+		{
+			name: "event handlers are removed",
+			text: `<a href="https://example.com" onclick="alert(1)">link</a>`,
+			want: `<a href="https://example.com">link</a>`,
+		},
+		{
+			name: "unsafe links are removed",
+			text: `<a href="javascript:alert(1)">link</a>`,
+			want: `<a>link</a>`,
+		},
+		{
+			name: "attribute values are escaped",
+			text: `<a href='https://example.com/?q="x"&amp;y'>link</a>`,
+			want: `<a href="https://example.com/?q=&#34;x&#34;&amp;y">link</a>`,
+		},
+		{
+			name: "external images are removed",
+			text: `<img src="https://example.com/image.png">`,
+			want: "",
+		},
+		{
+			name: "images from content repository are kept",
+			text: `<img width=10 src="mxc://example.com/id" onerror="alert(1)">`,
+			want: `<img src="mxc://example.com/id" width="10">`,
+		},
+		{
+			name: "code language",
+			text: `<code class="language-go">a</code><code class="evil other">b</code>`,
+			want: `<code class="language-go">a</code><code>b</code>`,
+		},
+		{
+			name: "colors",
+			text: `<font color="#FF0000">a</font><font color="red">b</font>`,
+			want: `<font color="#FF0000">a</font><font>b</font>`,
+		},
+		{
+			name: "stray characters are escaped",
+			text: "a < b && c > d",
+			want: "a &lt; b &amp;&amp; c &gt; d",
+		},
+		{
+			name: "entities are kept",
+			text: "&amp; &#39; &#x1F600; &lt;",
+			want: "&amp; &#39; &#x1F600; &lt;",
+		},
+		{
+			name: "unclosed tag is escaped",
+			text: "<b",
+			want: "&lt;b",
+		},
+		{
+			name: "unclosed tags are closed",
+			text: "<b><i>text",
+			want: "<b><i>text</i></b>",
+		},
+		{
+			name: "misnested tags",
+			text: "<b><i>a</b>b</i>",
+			want: "<b><i>a</i></b>b",
+		},
+		{
+			name: "stray closing tags are removed",
+			text: "a</b></script>",
+			want: "a",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			if got := sanitizeHTML(test.text); got != test.want {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
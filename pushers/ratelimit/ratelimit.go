@@ -0,0 +1,94 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket rate limiter. Bucket holds up to burst
+// tokens and is refilled with rate tokens per second, every sent message
+// takes one token.
+type Limiter struct {
+	// Time when tokens were last refilled.
+	updated time.Time
+	// Nothing should be sent until this time, e.g. because remote
+	// service asked us to slow down.
+	pausedUntil time.Time
+	mutex       sync.Mutex
+	rate        float64
+	burst       float64
+	// Available tokens. Negative value means that tokens are reserved by
+	// waiting senders.
+	tokens float64
+}
+
+// New creates limiter with full bucket.
+func New(rate float64, burst int) *Limiter {
+	// nolint:exhaustruct
+	return &Limiter{
+		updated: time.Now(),
+		rate:    rate,
+		burst:   float64(burst),
+		tokens:  float64(burst),
+	}
+}
+
+// Pause prevents sending for passed duration.
+func (l *Limiter) Pause(duration time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if until := time.Now().Add(duration); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+// Wait blocks until message can be sent.
+func (l *Limiter) Wait() {
+	time.Sleep(l.reserve())
+}
+
+// Takes token and returns time caller should wait before using it.
+func (l *Limiter) reserve() time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+
+	l.tokens += now.Sub(l.updated).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+
+	l.updated = now
+	l.tokens--
+
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+
+	// Senders waiting for pause end should still be paced.
+	if paused := l.pausedUntil.Sub(now); paused > 0 {
+		wait += paused
+	}
+
+	return wait
+}
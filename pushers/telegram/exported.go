@@ -18,13 +18,16 @@
 package telegrampusher
 
 import (
+	"sync"
+
 	"go.dev.pztrn.name/opensaps/context"
 	pusherinterface "go.dev.pztrn.name/opensaps/pushers/interface"
 )
 
 var (
-	ctx         *context.Context
-	connections map[string]*TelegramConnection
+	ctx              *context.Context
+	connections      map[string]*TelegramConnection
+	connectionsMutex sync.RWMutex
 )
 
 func New(cc *context.Context) {
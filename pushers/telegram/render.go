@@ -0,0 +1,174 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package telegrampusher
+
+import (
+	"html"
+	"strings"
+
+	parserinterface "go.dev.pztrn.name/opensaps/parsers/interface"
+	"go.dev.pztrn.name/opensaps/slack/mrkdwn"
+)
+
+// Renders mrkdwn nodes as HTML subset supported by Telegram Bot API.
+// Telegram doesn't support "<br>", line breaks are sent as is.
+func renderHTML(nodes []*mrkdwn.Node) string {
+	var builder strings.Builder
+
+	writeHTML(&builder, nodes)
+
+	return strings.TrimSuffix(builder.String(), "\n")
+}
+
+func writeHTML(builder *strings.Builder, nodes []*mrkdwn.Node) {
+	for _, node := range nodes {
+		// Blocks should start on new line.
+		if node.IsBlock() && builder.Len() != 0 && !strings.HasSuffix(builder.String(), "\n") {
+			builder.WriteString("\n")
+		}
+
+		switch node.Type {
+		case mrkdwn.NodeText, mrkdwn.NodeMention:
+			builder.WriteString(html.EscapeString(node.Text))
+		case mrkdwn.NodeBold:
+			writeHTMLTag(builder, "b", node.Children)
+		case mrkdwn.NodeItalic:
+			writeHTMLTag(builder, "i", node.Children)
+		case mrkdwn.NodeStrike:
+			writeHTMLTag(builder, "s", node.Children)
+		case mrkdwn.NodeCode:
+			builder.WriteString("<code>" + html.EscapeString(node.Text) + "</code>")
+		case mrkdwn.NodeCodeBlock:
+			builder.WriteString("<pre>" + html.EscapeString(node.Text) + "</pre>")
+		case mrkdwn.NodeQuote:
+			writeHTMLTag(builder, "blockquote", node.Children)
+		case mrkdwn.NodeLink:
+			// Unsafe links are displayed as labels only.
+			if !mrkdwn.IsSafeURL(node.URL) {
+				writeHTML(builder, node.Children)
+
+				break
+			}
+
+			builder.WriteString(`<a href="` + html.EscapeString(node.URL) + `">`)
+			writeHTML(builder, node.Children)
+			builder.WriteString("</a>")
+		case mrkdwn.NodeLineBreak:
+			builder.WriteString("\n")
+		}
+
+		// Anything after block should start on new line too.
+		if node.IsBlock() {
+			builder.WriteString("\n")
+		}
+	}
+}
+
+func writeHTMLTag(builder *strings.Builder, tag string, children []*mrkdwn.Node) {
+	builder.WriteString("<" + tag + ">")
+	writeHTML(builder, children)
+	builder.WriteString("</" + tag + ">")
+}
+
+// Renders parsed message as HTML subset supported by Telegram.
+func renderMessage(msg parserinterface.ParsedMessage) string {
+	lines := make([]string, 0, len(msg.Blocks)+len(msg.Fields)+len(msg.Images)+3)
+
+	if msg.Title != "" {
+		lines = append(lines, "<b>"+htmlLink(msg.Title, msg.TitleLink)+"</b>")
+	}
+
+	if msg.Author.Name != "" {
+		lines = append(lines, "<i>"+htmlLink(msg.Author.Name, msg.Author.Link)+"</i>")
+	}
+
+	for _, block := range msg.Blocks {
+		switch block.Type {
+		case parserinterface.BlockText:
+			lines = append(lines, renderHTML(mrkdwn.ParseAs(block.Text, block.Format)))
+		case parserinterface.BlockHeader:
+			lines = append(lines, "<b>"+htmlLink(block.Text, block.URL)+"</b>")
+		case parserinterface.BlockContext:
+			lines = append(lines, "<i>"+renderHTML(mrkdwn.ParseAs(block.Text, block.Format))+"</i>")
+		case parserinterface.BlockFields:
+			lines = append(lines, renderFields(block.Fields)...)
+		case parserinterface.BlockImage:
+			lines = append(lines, renderImage(block.Image))
+		case parserinterface.BlockDivider:
+			lines = append(lines, "——————")
+		}
+	}
+
+	lines = append(lines, renderFields(msg.Fields)...)
+
+	for _, image := range msg.Images {
+		lines = append(lines, renderImage(image))
+	}
+
+	if footer := msg.FooterLine(); footer != "" {
+		lines = append(lines, "<i>"+html.EscapeString(footer)+"</i>")
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Renders fields one per line. Like Slack does, two consecutive short
+// fields are placed on same line.
+func renderFields(fields []parserinterface.Field) []string {
+	lines := make([]string, 0, len(fields))
+	pairable := false
+
+	for _, field := range fields {
+		line := renderHTML(mrkdwn.ParseAs(field.Value, field.Format))
+		// Block Kit fields have no titles.
+		if field.Title != "" {
+			line = "<b>" + html.EscapeString(field.Title) + ":</b> " + line
+		}
+
+		if field.Short && pairable {
+			lines[len(lines)-1] += "  |  " + line
+			pairable = false
+
+			continue
+		}
+
+		lines = append(lines, line)
+		pairable = field.Short
+	}
+
+	return lines
+}
+
+func renderImage(image parserinterface.Image) string {
+	label := image.AltText
+	if label == "" {
+		label = image.URL
+	}
+
+	return htmlLink(label, image.URL)
+}
+
+// Returns link to URL with passed plain text label. If URL is empty or
+// unsafe - only label is returned.
+func htmlLink(label string, url string) string {
+	if url == "" || !mrkdwn.IsSafeURL(url) {
+		return html.EscapeString(label)
+	}
+
+	return `<a href="` + html.EscapeString(url) + `">` + html.EscapeString(label) + "</a>"
+}
@@ -0,0 +1,343 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package telegrampusher
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Maximum message length accepted by Telegram's sendMessage.
+const messageLengthLimit = 4096
+
+// HTML tag opened in message part.
+type openTag struct {
+	// Name of tag, e.g. "a".
+	name string
+	// Tag as it appeared in message, e.g. `<a href="...">`.
+	tag string
+}
+
+// Point in message part where it can be split.
+type splitPoint struct {
+	open []openTag
+	// Offset of split point in part.
+	offset int
+	// Length of separator which should be dropped.
+	skip int
+}
+
+// Splits HTML message into parts of at most limit characters. Message
+// is split on line breaks if possible, then on spaces. Tags and entities
+// are never split, tags that are open at the end of part are closed and
+// reopened at the beginning of next part.
+type splitter struct {
+	part      strings.Builder
+	open      []openTag
+	parts     []string
+	lastBreak *splitPoint
+	lastSpace *splitPoint
+	// Start of opening tags part ends with. Part shouldn't end with
+	// empty tags if it's split after them.
+	tagsStart *splitPoint
+	limit     int
+	// Length of tags reopened at the beginning of part, in bytes.
+	prefix int
+	// Part's length as counted by Telegram. Tags and entities are counted
+	// too, so real length is never bigger.
+	length int
+}
+
+// Splits message into parts which fit into Telegram's message length
+// limit. If more than maxParts parts are required - last part is
+// truncated and marker is appended to it.
+func splitMessage(message string, maxParts int, marker string) []string {
+	parts := splitHTML(message, messageLengthLimit)
+	if len(parts) <= maxParts {
+		return parts
+	}
+
+	// Last part is a valid HTML on it's own, so it can be split again to
+	// free space for marker.
+	last := splitHTML(parts[maxParts-1], messageLengthLimit-textLength(marker)-1)[0]
+
+	return append(parts[:maxParts-1], last+"\n"+marker)
+}
+
+func splitHTML(message string, limit int) []string {
+	// nolint:exhaustruct
+	sp := &splitter{limit: limit}
+
+	for position := 0; position < len(message); {
+		token := nextToken(message[position:])
+		sp.add(token)
+
+		position += len(token)
+	}
+
+	if sp.part.Len() != 0 {
+		sp.finishPart()
+	}
+
+	if len(sp.parts) == 0 {
+		return []string{message}
+	}
+
+	return sp.parts
+}
+
+// Returns tag, entity or single character message starts with.
+func nextToken(message string) string {
+	switch message[0] {
+	case '<':
+		if end := strings.IndexByte(message, '>'); end != -1 {
+			return message[:end+1]
+		}
+	case '&':
+		// nolint:gomnd
+		if end := strings.IndexByte(message, ';'); end != -1 && end <= 10 {
+			return message[:end+1]
+		}
+	}
+
+	_, size := utf8.DecodeRuneInString(message)
+
+	return message[:size]
+}
+
+func (sp *splitter) add(token string) {
+	if sp.skipAtPartStart(token) {
+		return
+	}
+
+	// Part which has only opening tags can't be split, even if tags alone
+	// are longer than limit.
+	hasContent := sp.part.Len() > sp.prefix && (sp.tagsStart == nil || sp.tagsStart.offset > sp.prefix)
+
+	// Remember where part can be split. Split point might be recorded for
+	// token which will be written after split, so it's offset might be
+	// equal to part's length.
+	switch {
+	case token == "\n":
+		sp.lastBreak = sp.splitPoint(len(token))
+	case token == " ":
+		sp.lastSpace = sp.splitPoint(len(token))
+	case strings.HasPrefix(token, "<") && !strings.HasPrefix(token, "</"):
+		if sp.tagsStart == nil {
+			sp.tagsStart = sp.splitPoint(0)
+		}
+	}
+
+	if !strings.HasPrefix(token, "<") || strings.HasPrefix(token, "</") {
+		sp.tagsStart = nil
+	}
+
+	open := sp.open
+
+	switch {
+	case strings.HasPrefix(token, "</"):
+		open = closeTag(open, tagName(token))
+	case strings.HasPrefix(token, "<"):
+		// Tags list is shared with split points, so it should be copied.
+		// nolint:exhaustruct
+		open = append(open[:len(open):len(open)], openTag{name: tagName(token), tag: token})
+	}
+
+	if sp.length+textLength(token)+closingLength(open) > sp.limit && hasContent {
+		sp.split(textLength(token) + closingLength(open))
+
+		if sp.skipAtPartStart(token) {
+			return
+		}
+	}
+
+	sp.part.WriteString(token)
+	sp.length += textLength(token)
+	sp.open = open
+}
+
+// Finishes current part, closing all open tags.
+func (sp *splitter) finishPart() {
+	for i := len(sp.open) - 1; i >= 0; i-- {
+		sp.part.WriteString("</" + sp.open[i].name + ">")
+	}
+
+	sp.parts = append(sp.parts, sp.part.String())
+}
+
+// Returns true if token shouldn't be written at the beginning of part:
+// separator on which message was split or closing tag for tag which was
+// just reopened.
+func (sp *splitter) skipAtPartStart(token string) bool {
+	if sp.part.Len() != sp.prefix {
+		return false
+	}
+
+	if len(sp.parts) != 0 && isSeparator(token) {
+		return true
+	}
+
+	if strings.HasPrefix(token, "</") && len(sp.open) != 0 && sp.open[len(sp.open)-1].name == tagName(token) {
+		sp.removeLastReopened()
+
+		return true
+	}
+
+	return false
+}
+
+// Splits current part on last line break or space, if rest of part fits
+// into next part along with token which is being added, or at current
+// position otherwise.
+func (sp *splitter) split(tokenLength int) {
+	rest := ""
+	open := sp.open
+
+	for _, point := range []*splitPoint{sp.lastBreak, sp.lastSpace, sp.tagsStart} {
+		if point == nil || point.offset <= sp.prefix {
+			continue
+		}
+
+		candidate := ""
+		if point.offset+point.skip <= sp.part.Len() {
+			candidate = sp.part.String()[point.offset+point.skip:]
+		}
+
+		if openingLength(point.open)+textLength(candidate)+tokenLength > sp.limit {
+			continue
+		}
+
+		rest = candidate
+		text := sp.part.String()[:point.offset]
+
+		sp.part.Reset()
+		sp.part.WriteString(text)
+
+		sp.open = point.open
+
+		break
+	}
+
+	sp.finishPart()
+
+	sp.part.Reset()
+	sp.lastBreak = nil
+	sp.lastSpace = nil
+	sp.tagsStart = nil
+
+	// Tags which are closed right at the beginning of next part shouldn't
+	// be reopened.
+	for len(sp.open) != 0 && strings.HasPrefix(rest, "</"+sp.open[len(sp.open)-1].name+">") {
+		rest = rest[len("</"+sp.open[len(sp.open)-1].name+">"):]
+		sp.open = sp.open[:len(sp.open)-1]
+	}
+
+	for _, tag := range sp.open {
+		sp.part.WriteString(tag.tag)
+	}
+
+	sp.prefix = sp.part.Len()
+	sp.part.WriteString(rest)
+	sp.length = textLength(sp.part.String())
+	sp.open = open
+}
+
+// Removes last tag reopened at the beginning of part.
+func (sp *splitter) removeLastReopened() {
+	tag := sp.open[len(sp.open)-1].tag
+	text := sp.part.String()[:sp.part.Len()-len(tag)]
+
+	sp.part.Reset()
+	sp.part.WriteString(text)
+
+	sp.prefix -= len(tag)
+	sp.length -= textLength(tag)
+	sp.open = sp.open[: len(sp.open)-1 : len(sp.open)-1]
+}
+
+func (sp *splitter) splitPoint(skip int) *splitPoint {
+	return &splitPoint{
+		open:   sp.open,
+		offset: sp.part.Len(),
+		skip:   skip,
+	}
+}
+
+// Returns tags list with last tag with passed name (and everything
+// opened after it) closed.
+func closeTag(open []openTag, name string) []openTag {
+	for i := len(open) - 1; i >= 0; i-- {
+		if open[i].name == name {
+			return open[:i:i]
+		}
+	}
+
+	return open
+}
+
+// Returns length of closing tags for passed tags.
+func closingLength(open []openTag) int {
+	length := 0
+
+	for _, tag := range open {
+		length += len("</" + tag.name + ">")
+	}
+
+	return length
+}
+
+// Returns length of passed tags.
+func openingLength(open []openTag) int {
+	length := 0
+
+	for _, tag := range open {
+		length += textLength(tag.tag)
+	}
+
+	return length
+}
+
+func isSeparator(token string) bool {
+	return token == "\n" || token == " "
+}
+
+// Returns tag's name, e.g. "a" for `<a href="...">` or "</a>".
+func tagName(tag string) string {
+	name := strings.TrimLeft(strings.TrimSuffix(tag, ">"), "</")
+	if end := strings.IndexAny(name, " \t\n"); end != -1 {
+		name = name[:end]
+	}
+
+	return strings.ToLower(name)
+}
+
+// Returns text length in UTF-16 code units, like Telegram counts it.
+func textLength(text string) int {
+	length := 0
+
+	for _, r := range text {
+		length++
+
+		// nolint:gomnd
+		if r > 0xFFFF {
+			length++
+		}
+	}
+
+	return length
+}
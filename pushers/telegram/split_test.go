@@ -0,0 +1,277 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package telegrampusher
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// Returns message's text without tags and whitespace.
+func stripTags(t *testing.T, message string) string {
+	t.Helper()
+
+	var builder strings.Builder
+
+	for position := 0; position < len(message); {
+		token := nextToken(message[position:])
+		position += len(token)
+
+		if !strings.HasPrefix(token, "<") && !isSeparator(token) {
+			builder.WriteString(token)
+		}
+	}
+
+	return builder.String()
+}
+
+// Checks that every tag in part is closed in correct order.
+func checkBalanced(t *testing.T, part string) {
+	t.Helper()
+
+	open := make([]string, 0)
+
+	for position := 0; position < len(part); {
+		token := nextToken(part[position:])
+		position += len(token)
+
+		switch {
+		case strings.HasPrefix(token, "</"):
+			if len(open) == 0 || open[len(open)-1] != tagName(token) {
+				t.Fatalf("unexpected closing tag %q in part %q", token, part)
+			}
+
+			open = open[:len(open)-1]
+		case strings.HasPrefix(token, "<"):
+			open = append(open, tagName(token))
+		}
+	}
+
+	if len(open) != 0 {
+		t.Fatalf("tags %v aren't closed in part %q", open, part)
+	}
+}
+
+func TestSplitHTML(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		limit   int
+		want    []string
+	}{
+		{
+			name:    "short message",
+			message: "short <b>message</b>",
+			limit:   100,
+			want:    []string{"short <b>message</b>"},
+		},
+		{
+			name:    "split on line break",
+			message: "first line\nsecond line",
+			limit:   15,
+			want:    []string{"first line", "second line"},
+		},
+		{
+			name:    "split on space",
+			message: "first second third",
+			limit:   13,
+			want:    []string{"first second", "third"},
+		},
+		{
+			name:    "space exceeds limit",
+			message: "aaaaa b",
+			limit:   5,
+			want:    []string{"aaaaa", "b"},
+		},
+		{
+			name:    "line break exceeds limit",
+			message: "aaaaa\nb",
+			limit:   5,
+			want:    []string{"aaaaa", "b"},
+		},
+		{
+			name:    "no separators",
+			message: "aaaaaaaaaa",
+			limit:   4,
+			want:    []string{"aaaa", "aaaa", "aa"},
+		},
+		{
+			name:    "tags are reopened",
+			message: "<b>aaaa bbbb</b>",
+			limit:   12,
+			want:    []string{"<b>aaaa</b>", "<b>bbbb</b>"},
+		},
+		{
+			name:    "link is reopened",
+			message: `<a href="u">aa bb</a>`,
+			limit:   20,
+			want:    []string{`<a href="u">aa</a>`, `<a href="u">bb</a>`},
+		},
+		{
+			name:    "entities aren't split",
+			message: "&amp;&amp;&amp;",
+			limit:   7,
+			want:    []string{"&amp;", "&amp;", "&amp;"},
+		},
+		{
+			name:    "closed tag isn't reopened",
+			message: "<pre>aaaa\n</pre>bbbbbb",
+			limit:   15,
+			want:    []string{"<pre>aaaa</pre>", "bbbbbb"},
+		},
+		{
+			name:    "tag longer than limit",
+			message: `<a href="https://example.com">x</a> y`,
+			limit:   10,
+			want:    []string{`<a href="https://example.com">x</a>`, "y"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			got := splitHTML(test.message, test.limit)
+			if strings.Join(got, "|") != strings.Join(test.want, "|") {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		name      string
+		message   string
+		maxParts  int
+		wantParts int
+		truncated bool
+	}{
+		{
+			name:      "fits into one message",
+			message:   strings.Repeat("a", messageLengthLimit),
+			maxParts:  5,
+			wantParts: 1,
+		},
+		{
+			name:      "space right after limit",
+			message:   strings.Repeat("a", messageLengthLimit) + " b",
+			maxParts:  5,
+			wantParts: 2,
+		},
+		{
+			name:      "line break right after limit",
+			message:   "<b>" + strings.Repeat("a", messageLengthLimit-7) + "</b>\nb",
+			maxParts:  5,
+			wantParts: 2,
+		},
+		{
+			name:      "surrogate pairs are counted twice",
+			message:   strings.Repeat("😀", messageLengthLimit/2+1),
+			maxParts:  5,
+			wantParts: 2,
+		},
+		{
+			name:      "truncated",
+			message:   strings.Repeat("line of text\n", 2000),
+			maxParts:  2,
+			wantParts: 2,
+			truncated: true,
+		},
+		{
+			name:      "truncated inside tag",
+			message:   "<pre>" + strings.Repeat("line of code\n", 2000) + "</pre>",
+			maxParts:  1,
+			wantParts: 1,
+			truncated: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			parts := splitMessage(test.message, test.maxParts, "[cut]")
+			if len(parts) != test.wantParts {
+				t.Fatalf("got %d parts, want %d", len(parts), test.wantParts)
+			}
+
+			for _, part := range parts {
+				if textLength(part) > messageLengthLimit {
+					t.Fatalf("part is %d characters long", textLength(part))
+				}
+
+				checkBalanced(t, part)
+			}
+
+			if truncated := strings.HasSuffix(parts[len(parts)-1], "\n[cut]"); truncated != test.truncated {
+				t.Fatalf("truncated is %v, want %v", truncated, test.truncated)
+			}
+
+			if !test.truncated && stripTags(t, strings.Join(parts, "")) != stripTags(t, test.message) {
+				t.Fatal("message text was changed")
+			}
+		})
+	}
+}
+
+func TestSplitHTMLRandom(t *testing.T) {
+	words := []string{"a", "bb", "é", "😀", "&amp;", "word"}
+	separators := []string{" ", "\n"}
+	tags := [][2]string{{"<b>", "</b>"}, {"<i>", "</i>"}, {`<a href="u">`, "</a>"}, {"<pre>", "</pre>"}}
+	random := rand.New(rand.NewSource(1))
+
+	// Returns random text, possibly wrapped in tags.
+	var text func(depth int) string
+	text = func(depth int) string {
+		var builder strings.Builder
+
+		for i := random.Intn(20); i > 0; i-- {
+			// nolint:gomnd
+			if depth < 2 && random.Intn(5) == 0 {
+				tag := tags[random.Intn(len(tags))]
+				builder.WriteString(tag[0] + text(depth+1) + tag[1])
+			} else {
+				builder.WriteString(words[random.Intn(len(words))])
+			}
+
+			builder.WriteString(separators[random.Intn(len(separators))])
+		}
+
+		return builder.String()
+	}
+
+	for i := 0; i < 3000; i++ {
+		message := text(0)
+		limit := 40 + random.Intn(40)
+		parts := splitHTML(message, limit)
+
+		for _, part := range parts {
+			if textLength(part) > limit {
+				t.Fatalf("part %q of message %q is longer than %d", part, message, limit)
+			}
+
+			checkBalanced(t, part)
+		}
+
+		if stripTags(t, strings.Join(parts, "")) != stripTags(t, message) {
+			t.Fatalf("text of message %q was changed", message)
+		}
+	}
+}
@@ -18,49 +18,106 @@
 package telegrampusher
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	configstruct "go.dev.pztrn.name/opensaps/config/struct"
+	pusherinterface "go.dev.pztrn.name/opensaps/pushers/interface"
+	"go.dev.pztrn.name/opensaps/pushers/ratelimit"
 	slackmessage "go.dev.pztrn.name/opensaps/slack/message"
 )
 
+const (
+	// How often bot check should be repeated while it fails.
+	botRecheckInterval = 30 * time.Second
+	// Telegram Bot API root URL.
+	defaultAPIRoot = "https://api.telegram.org"
+	// Separates IDs of message's parts in message ID.
+	messageIDsSeparator = ","
+	// Stands for ID of sent part which wasn't decoded from Telegram's
+	// reply in partially sent message's ID.
+	unknownPartID = "?"
+	// Number of attempts to call method before giving up.
+	maxCallAttempts = 3
+	// Maximum delay Telegram might ask to wait before next attempt. If
+	// Telegram asked to wait longer - delivery queue will retry later.
+	maxRetryDelay = 30 * time.Second
+)
+
+// Bot API method call reply.
+type telegramReply struct {
+	Result      json.RawMessage             `json:"result"`
+	Parameters  *telegramResponseParameters `json:"parameters"`
+	Description string                      `json:"description"`
+	ErrorCode   int                         `json:"error_code"`
+	OK          bool                        `json:"ok"`
+}
+
+// Additional information about failed method call.
+// nolint:tagliatelle
+type telegramResponseParameters struct {
+	// Chat ID of supergroup to which group was migrated.
+	MigrateToChatID int64 `json:"migrate_to_chat_id"`
+	// Number of seconds to wait before method can be called again.
+	RetryAfter int `json:"retry_after"`
+}
+
 type TelegramConnection struct {
-	config configstruct.ConfigTelegram
+	// Last bot check time.
+	lastCheck time.Time
+	config    configstruct.ConfigTelegram
+	// Limits rate of sent messages.
+	limiter *ratelimit.Limiter
+	// Chat ID messages are sent to. Differs from configured one if group
+	// was migrated to supergroup.
+	chatID   string
+	connName string
+	// Last error occurred while checking bot.
+	lastError string
+	// Messages that are being sent right now.
+	inFlight sync.WaitGroup
+	// Bot API root URL.
+	apiRoot string
+	// Protects chatID, lastCheck, lastError and ready.
+	stateMutex sync.Mutex
+	// Shows that bot check succeeded.
+	ready bool
 }
 
 func (tc *TelegramConnection) Initialize(connName string, cfg configstruct.ConfigTelegram) {
+	tc.connName = connName
 	tc.config = cfg
+	tc.apiRoot = defaultAPIRoot
+	tc.chatID = cfg.ChatID
+	tc.limiter = ratelimit.New(cfg.GetRateLimit(), cfg.GetRateBurst())
 }
 
-func (tc *TelegramConnection) ProcessMessage(message slackmessage.SlackMessage) {
-	// Prepare message body.
-	messageData := ctx.SendToParser(message.Username, message)
+// Checks that bot token is valid and Telegram is reachable by calling
+// getMe method.
+func (tc *TelegramConnection) checkBot() {
+	tc.stateMutex.Lock()
+	tc.lastCheck = time.Now()
+	tc.stateMutex.Unlock()
 
-	messageToSend, _ := messageData["message"].(string)
-	// We'll use HTML, so reformat links accordingly (if any).
-	linksRaw, linksFound := messageData["links"]
-	if linksFound {
-		links, _ := linksRaw.([][]string)
-		for _, link := range links {
-			messageToSend = strings.ReplaceAll(messageToSend, link[0], `<a href="`+link[1]+`">`+link[2]+`</a>`)
-		}
+	err := tc.getMe()
+	if err != nil {
+		ctx.Log.Error().Err(err).Str("conn", tc.connName).Msg("Telegram bot check failed")
 	}
 
-	ctx.Log.Debug().Msgf("Crafted message: %s", messageToSend)
-
-	// Send message.
-	tc.SendMessage(messageToSend)
+	tc.setState(err)
 }
 
-func (tc *TelegramConnection) SendMessage(message string) {
-	msgdata := url.Values{}
-	msgdata.Set("chat_id", tc.config.ChatID)
-	msgdata.Set("text", message)
-	msgdata.Set("parse_mode", "HTML")
-
+// Returns HTTP client which uses configured proxy (if any).
+func (tc *TelegramConnection) getClient() *http.Client {
 	// Are we should use proxy?
 	// nolint:exhaustruct
 	httpTransport := &http.Transport{}
@@ -88,26 +145,404 @@ func (tc *TelegramConnection) SendMessage(message string) {
 		}
 	}
 
+	// nolint:exhaustruct,gomnd
+	return &http.Client{Transport: httpTransport, Timeout: 30 * time.Second}
+}
+
+// Returns marker appended to truncated message, escaped for HTML.
+func (tc *TelegramConnection) getTruncationMarker() string {
+	return html.EscapeString(tc.config.GetTruncationMarker())
+}
+
+// Returns chat ID messages should be sent to.
+func (tc *TelegramConnection) getChatID() string {
+	tc.stateMutex.Lock()
+	defer tc.stateMutex.Unlock()
+
+	return tc.chatID
+}
+
+func (tc *TelegramConnection) getMe() error {
+	botURL := fmt.Sprintf("%s/bot%s/getMe", tc.apiRoot, tc.config.BotID)
+
+	// nolint:noctx
+	response, err := tc.getClient().Get(botURL)
+	if err != nil {
+		return fmt.Errorf("failed to call getMe: %w", err)
+	}
+
+	defer response.Body.Close()
+
+	body, _ := ioutil.ReadAll(response.Body)
+
+	// nolint:exhaustruct
+	reply := struct {
+		Result struct {
+			Username string `json:"username"`
+		} `json:"result"`
+		Description string `json:"description"`
+		OK          bool   `json:"ok"`
+	}{}
+
+	if err := json.Unmarshal(body, &reply); err != nil {
+		// nolint:goerr113
+		return fmt.Errorf("failed to decode getMe reply (status %s): %w", response.Status, err)
+	}
+
+	if !reply.OK {
+		// nolint:goerr113
+		return errors.New("getMe failed: " + response.Status + ", " + reply.Description)
+	}
+
+	ctx.Log.Info().Str("conn", tc.connName).Str("bot", reply.Result.Username).Msg("Telegram bot check succeeded")
+
+	return nil
+}
+
+// Replaces chat ID after group was migrated to supergroup. New chat ID
+// is kept in memory only, so configuration should be updated.
+func (tc *TelegramConnection) migrateChat(newChatID string) {
+	tc.stateMutex.Lock()
+	oldChatID := tc.chatID
+	tc.chatID = newChatID
+	tc.stateMutex.Unlock()
+
+	ctx.Log.Warn().Str("conn", tc.connName).Str("old_chat_id", oldChatID).Str("new_chat_id", newChatID).
+		Msg("Group was migrated to supergroup, messages will be sent to new chat ID. Please update chat_id in configuration")
+}
+
+func (tc *TelegramConnection) setState(err error) {
+	tc.stateMutex.Lock()
+	defer tc.stateMutex.Unlock()
+
+	tc.ready = err == nil
+	tc.lastError = ""
+
+	if err != nil {
+		tc.lastError = ctx.Redact(err.Error())
+	}
+}
+
+// Status returns connection's status. If bot check failed - it will be
+// repeated in background. Connection name should be filled by caller.
+func (tc *TelegramConnection) Status() pusherinterface.ConnectionStatus {
+	tc.stateMutex.Lock()
+	defer tc.stateMutex.Unlock()
+
+	if !tc.ready && !tc.lastCheck.IsZero() && time.Since(tc.lastCheck) > botRecheckInterval {
+		tc.lastCheck = time.Now()
+
+		go tc.checkBot()
+	}
+
+	// nolint:exhaustruct
+	status := pusherinterface.ConnectionStatus{
+		Pusher: "telegram",
+		Error:  tc.lastError,
+		Ready:  tc.ready,
+	}
+
+	if !status.Ready && status.Error == "" {
+		status.Error = "bot check wasn't completed yet"
+	}
+
+	return status
+}
+
+// Message is sent as reply if replied message ID is passed. If message
+// was partially sent by previous attempt - it's ID should be passed too.
+func (tc *TelegramConnection) ProcessMessage(message slackmessage.SlackMessage, replyTo string,
+	sentMessageID string) (string, error) {
+	// Prepare message body.
+	messageData := ctx.SendToParser(message.Username, message)
+
+	if messageData.IsEmpty() {
+		ctx.Log.Debug().Str("conn", tc.connName).Msg("Parsed message is empty, nothing to send")
+
+		return "", nil
+	}
+
+	// We'll send message as HTML.
+	messageToSend := renderMessage(messageData)
+
+	ctx.Log.Debug().Msgf("Crafted message: %s", messageToSend)
+
+	// Send message.
+	return tc.SendMessage(messageToSend, replyTo, sentMessageID)
+}
+
+// Same as ProcessMessage, but edits previously sent message.
+func (tc *TelegramConnection) ProcessUpdate(messageID string, message slackmessage.SlackMessage) error {
+	messageData := ctx.SendToParser(message.Username, message)
+
+	if messageData.IsEmpty() {
+		ctx.Log.Debug().Str("conn", tc.connName).Msg("Parsed message is empty, nothing to update")
+
+		return nil
+	}
+
+	return tc.EditMessage(messageID, renderMessage(messageData))
+}
+
+// DeleteMessage deletes previously sent message with all it's parts.
+func (tc *TelegramConnection) DeleteMessage(messageID string) error {
+	return tc.deleteParts(splitMessageIDs(messageID))
+}
+
+// EditMessage replaces text of previously sent message. If message was
+// split into several parts - only first part is edited, so new text is
+// truncated to fit into single message, and other parts are deleted.
+func (tc *TelegramConnection) EditMessage(messageID string, message string) error {
+	partsIDs := splitMessageIDs(messageID)
+
+	msgdata := url.Values{}
+	msgdata.Set("chat_id", tc.getChatID())
+	msgdata.Set("message_id", partsIDs[0])
+	msgdata.Set("text", splitMessage(message, 1, tc.getTruncationMarker())[0])
+	msgdata.Set("parse_mode", "HTML")
+
+	reply, err := tc.callMethod("editMessageText", msgdata)
+	if err != nil && !strings.Contains(reply.Description, "message is not modified") {
+		return err
+	}
+
+	return tc.deleteParts(partsIDs[1:])
+}
+
+// Deletes messages with passed IDs. Every message is tried to be deleted
+// even if deletion of some of them has failed, first error is returned.
+func (tc *TelegramConnection) deleteParts(partsIDs []string) error {
+	var firstErr error
+
+	for _, partID := range partsIDs {
+		msgdata := url.Values{}
+		msgdata.Set("chat_id", tc.getChatID())
+		msgdata.Set("message_id", partID)
+
+		reply, err := tc.callMethod("deleteMessage", msgdata)
+		if err != nil && strings.Contains(reply.Description, "message to delete not found") {
+			ctx.Log.Debug().Str("conn", tc.connName).Str("message_id", partID).Msg("Message was already deleted")
+
+			continue
+		}
+
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// SendMessage sends message, as reply if replied message ID is passed,
+// and returns it's ID. Long message is split into several messages which
+// are sent in order, their IDs are returned separated by comma.
+// If some part fails to send - returned delivery error contains IDs of
+// already sent parts as sent message ID. When it's passed back, sending
+// continues from first unsent part.
+func (tc *TelegramConnection) SendMessage(message string, replyTo string, sentMessageID string) (string, error) {
+	parts := splitMessage(message, tc.config.GetMaxParts(), tc.getTruncationMarker())
+	if len(parts) > 1 {
+		ctx.Log.Debug().Str("conn", tc.connName).Int("parts", len(parts)).Msg("Message is too long, splitting it")
+	}
+
+	partsIDs := make([]string, 0, len(parts))
+	if sentMessageID != "" {
+		partsIDs = append(partsIDs, splitMessageIDs(sentMessageID)...)
+
+		ctx.Log.Debug().Str("conn", tc.connName).Int("sent", len(partsIDs)).Int("parts", len(parts)).
+			Msg("Continuing to send partially sent message")
+	}
+
+	for i := len(partsIDs); i < len(parts); i++ {
+		// Only first part is sent as reply.
+		partReplyTo := ""
+		if i == 0 {
+			partReplyTo = replyTo
+		}
+
+		partID, err := tc.sendPart(parts[i], partReplyTo)
+		if err != nil {
+			return "", tc.withSentParts(err, partsIDs)
+		}
+
+		// Unknown IDs are kept, so sent parts could be counted.
+		if partID == "" {
+			partID = unknownPartID
+		}
+
+		partsIDs = append(partsIDs, partID)
+	}
+
+	return joinMessageIDs(partsIDs), nil
+}
+
+// Adds IDs of sent parts to delivery error, so retrying it won't produce
+// duplicates.
+func (tc *TelegramConnection) withSentParts(err error, partsIDs []string) error {
+	if len(partsIDs) == 0 {
+		return err
+	}
+
+	var deliveryErr *pusherinterface.DeliveryError
+	if !errors.As(err, &deliveryErr) {
+		deliveryErr = pusherinterface.NewDeliveryError(tc.connName, 0, err)
+	}
+
+	deliveryErr.SentMessageID = strings.Join(partsIDs, messageIDsSeparator)
+
+	ctx.Log.Warn().Err(err).Str("conn", tc.connName).Int("sent", len(partsIDs)).
+		Msg("Message was sent partially, rest of it will be sent on next attempt")
+
+	return deliveryErr
+}
+
+// Returns message ID made of known IDs of message's parts.
+func joinMessageIDs(partsIDs []string) string {
+	known := make([]string, 0, len(partsIDs))
+
+	for _, partID := range partsIDs {
+		if partID != unknownPartID {
+			known = append(known, partID)
+		}
+	}
+
+	return strings.Join(known, messageIDsSeparator)
+}
+
+// Returns IDs of message's parts. First part is always present.
+func splitMessageIDs(messageID string) []string {
+	return strings.Split(messageID, messageIDsSeparator)
+}
+
+// Sends single message and returns it's ID.
+func (tc *TelegramConnection) sendPart(message string, replyTo string) (string, error) {
+	msgdata := url.Values{}
+	msgdata.Set("chat_id", tc.getChatID())
+	msgdata.Set("text", message)
+	msgdata.Set("parse_mode", "HTML")
+
+	if replyTo != "" {
+		msgdata.Set("reply_to_message_id", replyTo)
+		// Replied message might be deleted already.
+		msgdata.Set("allow_sending_without_reply", "true")
+	}
+
+	reply, err := tc.callMethod("sendMessage", msgdata)
+	if err != nil {
+		return "", err
+	}
+
+	// Message was delivered, so connection is definitely ready.
+	tc.setState(nil)
+
+	// nolint:exhaustruct,tagliatelle
+	result := struct {
+		MessageID int64 `json:"message_id"`
+	}{}
+
+	// Message was sent anyway, so failed decoding shouldn't be reported
+	// as delivery error.
+	if err := json.Unmarshal(reply.Result, &result); err != nil {
+		ctx.Log.Warn().Err(err).Str("conn", tc.connName).Msg("Failed to decode message ID from Telegram reply")
+
+		return "", nil
+	}
+
+	return strconv.FormatInt(result.MessageID, 10), nil
+}
+
+// Calls Bot API method. Calls are paced to stay under Telegram's limits.
+// If Telegram asks to slow down - call is repeated after requested delay
+// and if group was migrated to supergroup - call is repeated with new
+// chat ID. Reply is returned even if call failed, if Telegram sent it.
+func (tc *TelegramConnection) callMethod(method string, data url.Values) (telegramReply, error) {
+	for attempt := 1; ; attempt++ {
+		tc.limiter.Wait()
+
+		reply, err := tc.doCall(method, data)
+		if err == nil || attempt >= maxCallAttempts || reply.Parameters == nil {
+			return reply, err
+		}
+
+		if reply.Parameters.MigrateToChatID != 0 && data.Get("chat_id") != "" {
+			newChatID := strconv.FormatInt(reply.Parameters.MigrateToChatID, 10)
+
+			tc.migrateChat(newChatID)
+			data.Set("chat_id", newChatID)
+
+			continue
+		}
+
+		delay := pusherinterface.GetRetryAfter(err)
+		if delay == 0 || delay > maxRetryDelay {
+			return reply, err
+		}
+
+		ctx.Log.Warn().Str("conn", tc.connName).Str("method", method).Int("attempt", attempt).Dur("delay", delay).
+			Msg("Telegram asked to slow down, will retry")
+
+		// Other messages should wait too.
+		tc.limiter.Pause(delay)
+	}
+}
+
+// Performs single Bot API method call.
+func (tc *TelegramConnection) doCall(method string, data url.Values) (telegramReply, error) {
 	// nolint:exhaustruct
-	client := &http.Client{Transport: httpTransport}
-	botURL := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", tc.config.BotID)
+	reply := telegramReply{}
+
+	client := tc.getClient()
+	botURL := fmt.Sprintf("%s/bot%s/%s", tc.apiRoot, tc.config.BotID, method)
 
 	ctx.Log.Debug().Msgf("Bot URL: %s", botURL)
 
 	// ToDo: fix it.
 	// nolint
-	response, err := client.PostForm(botURL, msgdata)
+	response, err := client.PostForm(botURL, data)
 	if err != nil {
-		ctx.Log.Error().Err(err).Msg("Error occurred while sending data to Telegram")
-	} else {
-		ctx.Log.Debug().Msgf("Status: %s", response.Status)
+		return reply, pusherinterface.NewDeliveryError(tc.connName, 0, fmt.Errorf("failed to send data to Telegram: %w", err))
+	}
+
+	defer response.Body.Close()
+
+	body, _ := ioutil.ReadAll(response.Body)
+
+	ctx.Log.Debug().Msgf("Status: %s", response.Status)
+
+	if err := json.Unmarshal(body, &reply); err != nil {
 		if response.StatusCode != http.StatusOK {
-			body := []byte{}
-			_, _ = response.Body.Read(body)
-			response.Body.Close()
-			ctx.Log.Debug().Msg(string(body))
+			// nolint:goerr113
+			return reply, pusherinterface.NewDeliveryError(tc.connName, response.StatusCode,
+				errors.New("Status: "+response.Status+", body: "+string(body)))
 		}
+
+		// Method was called anyway.
+		ctx.Log.Warn().Err(err).Str("conn", tc.connName).Str("method", method).Msg("Failed to decode Telegram reply")
+
+		return reply, nil
+	}
+
+	if response.StatusCode == http.StatusOK && reply.OK {
+		return reply, nil
 	}
+
+	ctx.Log.Debug().Msg(string(body))
+
+	statusCode := reply.ErrorCode
+	if statusCode == 0 {
+		statusCode = response.StatusCode
+	}
+
+	// nolint:goerr113
+	deliveryErr := pusherinterface.NewDeliveryError(tc.connName, statusCode,
+		errors.New("Status: "+response.Status+", error code: "+strconv.Itoa(reply.ErrorCode)+", description: "+reply.Description))
+
+	if reply.Parameters != nil && reply.Parameters.RetryAfter > 0 {
+		deliveryErr.RetryAfter = time.Duration(reply.Parameters.RetryAfter) * time.Second
+	}
+
+	return reply, deliveryErr
 }
 
 func (tc *TelegramConnection) Shutdown() {
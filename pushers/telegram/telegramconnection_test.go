@@ -0,0 +1,165 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package telegrampusher
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog"
+	configstruct "go.dev.pztrn.name/opensaps/config/struct"
+	"go.dev.pztrn.name/opensaps/context"
+	pusherinterface "go.dev.pztrn.name/opensaps/pushers/interface"
+)
+
+// Fake Bot API which fails sendMessage calls with passed numbers.
+type fakeBotAPI struct {
+	failCalls map[int]bool
+	// Texts of sent messages by message ID.
+	sent    map[string]string
+	replyTo map[string]string
+	calls   int
+	mutex   sync.Mutex
+}
+
+func (api *fakeBotAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	if !strings.HasSuffix(r.URL.Path, "/sendMessage") {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	api.calls++
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if api.failCalls[api.calls] {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"ok":false,"error_code":500,"description":"Internal Server Error"}`))
+
+		return
+	}
+
+	messageID := len(api.sent) + 1
+	api.sent[strconv.Itoa(messageID)] = r.FormValue("text")
+	api.replyTo[strconv.Itoa(messageID)] = r.FormValue("reply_to_message_id")
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": map[string]interface{}{"message_id": messageID}})
+}
+
+func newTestConnection(t *testing.T, api *fakeBotAPI) *TelegramConnection {
+	t.Helper()
+
+	// nolint:exhaustruct
+	ctx = &context.Context{Log: zerolog.Nop()}
+
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	// nolint:exhaustruct
+	conn := &TelegramConnection{}
+	// nolint:exhaustruct
+	conn.Initialize("test", configstruct.ConfigTelegram{BotID: "123:token", ChatID: "-1", RateLimit: 1000, RateBurst: 100})
+	conn.apiRoot = server.URL
+
+	return conn
+}
+
+func TestSendMessagePartFailure(t *testing.T) {
+	tests := []struct {
+		name      string
+		failCalls []int
+		// IDs of sent parts returned in error after first attempt.
+		wantSent string
+	}{
+		{name: "first part fails", failCalls: []int{1}, wantSent: ""},
+		{name: "second part fails", failCalls: []int{2}, wantSent: "1"},
+		{name: "third part fails", failCalls: []int{3}, wantSent: "1,2"},
+	}
+
+	line := strings.Repeat("a", messageLengthLimit-10)
+	message := line + "\n" + strings.Replace(line, "a", "b", 1) + "\n" + strings.Replace(line, "a", "c", 1)
+	parts := splitMessage(message, 5, "")
+
+	if len(parts) != 3 {
+		t.Fatalf("message should be split into 3 parts, got %d", len(parts))
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			// nolint:exhaustruct
+			api := &fakeBotAPI{failCalls: make(map[int]bool), sent: make(map[string]string), replyTo: make(map[string]string)}
+			for _, call := range test.failCalls {
+				api.failCalls[call] = true
+			}
+
+			conn := newTestConnection(t, api)
+
+			_, err := conn.SendMessage(message, "42", "")
+			if err == nil {
+				t.Fatal("first attempt should fail")
+			}
+
+			var deliveryErr *pusherinterface.DeliveryError
+			if !errors.As(err, &deliveryErr) || !deliveryErr.Temporary {
+				t.Fatalf("got %v, want temporary delivery error", err)
+			}
+
+			sentMessageID := pusherinterface.GetSentMessageID(err)
+			if sentMessageID != test.wantSent {
+				t.Fatalf("got sent message ID %q, want %q", sentMessageID, test.wantSent)
+			}
+
+			messageID, err := conn.SendMessage(message, "42", sentMessageID)
+			if err != nil {
+				t.Fatalf("second attempt failed: %v", err)
+			}
+
+			if messageID != "1,2,3" {
+				t.Fatalf("got message ID %q, want %q", messageID, "1,2,3")
+			}
+
+			for i, part := range parts {
+				partID := strconv.Itoa(i + 1)
+				if api.sent[partID] != part {
+					t.Fatalf("part %d was sent out of order or duplicated", i+1)
+				}
+
+				wantReplyTo := ""
+				if i == 0 {
+					wantReplyTo = "42"
+				}
+
+				if api.replyTo[partID] != wantReplyTo {
+					t.Fatalf("part %d replies to %q, want %q", i+1, api.replyTo[partID], wantReplyTo)
+				}
+			}
+		})
+	}
+}
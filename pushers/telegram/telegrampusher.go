@@ -18,6 +18,11 @@
 package telegrampusher
 
 import (
+	"fmt"
+	"sort"
+
+	configstruct "go.dev.pztrn.name/opensaps/config/struct"
+	pusherinterface "go.dev.pztrn.name/opensaps/pushers/interface"
 	slackmessage "go.dev.pztrn.name/opensaps/slack/message"
 )
 
@@ -28,33 +33,159 @@ func (tp TelegramPusher) Initialize() {
 
 	// Get configuration for pushers and initialize every connection.
 	cfg := ctx.Config.GetConfig()
-	for name, config := range cfg.Telegram {
-		ctx.Log.Info().Str("conn", name).Msg("Initializing connection...")
 
-		// nolint:exhaustruct
-		conn := TelegramConnection{}
-		connections[name] = &conn
+	connectionsMutex.Lock()
+	defer connectionsMutex.Unlock()
 
-		go conn.Initialize(name, config)
+	for name, config := range cfg.Telegram {
+		tp.startConnection(name, config)
 	}
 }
 
-func (tp TelegramPusher) Push(connection string, data slackmessage.SlackMessage) {
-	conn, found := connections[connection]
-	if !found {
-		ctx.Log.Error().Str("conn", connection).Msg("Connection not found")
+func (tp TelegramPusher) Delete(connection string, messageID string, _ string) error {
+	conn, err := tp.acquireConnection(connection)
+	if err != nil {
+		return err
+	}
+	defer conn.inFlight.Done()
 
-		return
+	return conn.DeleteMessage(messageID)
+}
+
+// Bot API has no way to avoid duplicates, so delivery ID is ignored.
+func (tp TelegramPusher) Push(connection string, data slackmessage.SlackMessage, _ string,
+	sentMessageID string) (string, error) {
+	conn, err := tp.acquireConnection(connection)
+	if err != nil {
+		return "", err
 	}
+	defer conn.inFlight.Done()
 
 	ctx.Log.Debug().Str("conn", connection).Msg("Pushing data")
-	conn.ProcessMessage(data)
+
+	return conn.ProcessMessage(data, tp.getRepliedMessage(connection, data), sentMessageID)
+}
+
+func (tp TelegramPusher) Reload() {
+	ctx.Log.Info().Msg("Reloading Telegram protocol pusher...")
+
+	cfg := ctx.Config.GetConfig()
+
+	connectionsMutex.Lock()
+	defer connectionsMutex.Unlock()
+
+	for name, conn := range connections {
+		config, found := cfg.Telegram[name]
+
+		switch {
+		case !found:
+			ctx.Log.Info().Str("conn", name).Msg("Connection was removed from configuration, shutting it down")
+		case conn.config != config:
+			ctx.Log.Info().Str("conn", name).Msg("Connection configuration was changed, re-creating it")
+		default:
+			continue
+		}
+
+		delete(connections, name)
+
+		go func(conn *TelegramConnection) {
+			conn.inFlight.Wait()
+			conn.Shutdown()
+		}(conn)
+	}
+
+	for name, config := range cfg.Telegram {
+		if _, found := connections[name]; !found {
+			tp.startConnection(name, config)
+		}
+	}
 }
 
 func (tp TelegramPusher) Shutdown() {
 	ctx.Log.Info().Msg("Shutting down Telegram pusher...")
 
+	connectionsMutex.RLock()
+	defer connectionsMutex.RUnlock()
+
 	for _, conn := range connections {
 		conn.Shutdown()
 	}
 }
+
+func (tp TelegramPusher) Status() []pusherinterface.ConnectionStatus {
+	connectionsMutex.RLock()
+	defer connectionsMutex.RUnlock()
+
+	statuses := make([]pusherinterface.ConnectionStatus, 0, len(connections))
+
+	for name, conn := range connections {
+		status := conn.Status()
+		status.Connection = name
+		statuses = append(statuses, status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Connection < statuses[j].Connection })
+
+	return statuses
+}
+
+func (tp TelegramPusher) Update(connection string, messageID string, data slackmessage.SlackMessage, _ string) error {
+	conn, err := tp.acquireConnection(connection)
+	if err != nil {
+		return err
+	}
+	defer conn.inFlight.Done()
+
+	return conn.ProcessUpdate(messageID, data)
+}
+
+// Returns ID of message which passed message replies to. Empty string
+// is returned if message isn't a reply or if replied message wasn't sent
+// by this connection.
+func (tp TelegramPusher) getRepliedMessage(connection string, data slackmessage.SlackMessage) string {
+	if data.ThreadTS == "" {
+		return ""
+	}
+
+	entry, found := ctx.MessageMap.Get(data.ThreadTS)
+	if !found || entry.MessageID("telegram", connection) == "" {
+		ctx.Log.Debug().Str("conn", connection).Str("thread_ts", data.ThreadTS).
+			Msg("Thread's message wasn't sent by connection, reply will be sent as usual message")
+
+		return ""
+	}
+
+	// Long message was split into several parts, reply to first one.
+	return splitMessageIDs(entry.MessageID("telegram", connection))[0]
+}
+
+// Returns connection with passed name and marks it as used, so it won't
+// be removed on reload until conn.inFlight.Done() will be called.
+func (tp TelegramPusher) acquireConnection(connection string) (*TelegramConnection, error) {
+	connectionsMutex.RLock()
+	defer connectionsMutex.RUnlock()
+
+	conn, found := connections[connection]
+	if !found {
+		ctx.Log.Error().Str("conn", connection).Msg("Connection not found")
+
+		return nil, fmt.Errorf("%w: %s", pusherinterface.ErrConnectionNotFound, connection)
+	}
+
+	conn.inFlight.Add(1)
+
+	return conn, nil
+}
+
+// Creates connection and checks bot in background. Should be called
+// with connections mutex locked.
+func (tp TelegramPusher) startConnection(name string, config configstruct.ConfigTelegram) {
+	ctx.Log.Info().Str("conn", name).Msg("Initializing connection...")
+
+	// nolint:exhaustruct
+	conn := &TelegramConnection{}
+	conn.Initialize(name, config)
+	connections[name] = conn
+
+	go conn.checkBot()
+}
@@ -0,0 +1,49 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package queue
+
+import (
+	"sync"
+
+	"go.dev.pztrn.name/opensaps/context"
+	queueinterface "go.dev.pztrn.name/opensaps/queue/interface"
+)
+
+var (
+	ctx *context.Context
+	// Queued messages storage.
+	storage store
+	// Queue directory, empty if messages are kept in memory.
+	directory string
+	// Serializes pending messages counting and adding.
+	enqueueMutex sync.Mutex
+	// Delivery workers, workersCount per destination.
+	workers      map[string]*worker
+	workersCount int
+	workersMutex sync.Mutex
+	// Closing this channel stops destinations scanning.
+	scannerStop chan struct{}
+)
+
+func New(cc *context.Context) {
+	ctx = cc
+	workers = make(map[string]*worker)
+
+	q := Queue{}
+	ctx.RegisterQueueInterface(queueinterface.QueueInterface(q))
+}
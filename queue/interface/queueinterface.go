@@ -0,0 +1,57 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package queueinterface
+
+import (
+	"errors"
+
+	configstruct "go.dev.pztrn.name/opensaps/config/struct"
+	slackmessage "go.dev.pztrn.name/opensaps/slack/message"
+)
+
+var (
+	// ErrEnqueueFailed returns when message cannot be put into queue.
+	ErrEnqueueFailed = errors.New("failed to enqueue message")
+	// ErrQueueFull returns when there are too many messages waiting for
+	// delivery to destination.
+	ErrQueueFull = errors.New("delivery queue is full")
+)
+
+// Actions queued message might perform.
+const (
+	// ActionPost sends new message.
+	ActionPost = ""
+	// ActionUpdate updates message previously sent with same timestamp.
+	ActionUpdate = "update"
+	// ActionDelete deletes message previously sent with same timestamp.
+	ActionDelete = "delete"
+)
+
+type QueueInterface interface {
+	// Enqueue stores message for delivery to every passed destination and
+	// returns IDs of queued messages. Message is queued either for all
+	// destinations or for none of them. Slack message timestamp is passed
+	// for messages sent with Web API, empty otherwise. Updates and
+	// deletions are queued too, so they will be performed after message
+	// itself will be delivered.
+	Enqueue(webhook string, remotes configstruct.ConfigWebhookRemotes, action string,
+		message slackmessage.SlackMessage, ts string) ([]string, error)
+	Initialize()
+	Reload()
+	Shutdown()
+}
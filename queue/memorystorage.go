@@ -0,0 +1,136 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package queue
+
+import (
+	"sync"
+
+	slackmessage "go.dev.pztrn.name/opensaps/slack/message"
+)
+
+// MemoryStorage keeps queued messages in memory. It is used when queue
+// directory isn't configured, so messages waiting for delivery will be
+// lost on restart. Dead messages aren't kept at all, they're only logged.
+type MemoryStorage struct {
+	// Pending messages by destination, ordered by time they were received.
+	pending map[Destination][]*Item
+	mutex   sync.Mutex
+}
+
+// NewMemoryStorage creates empty in-memory storage.
+func NewMemoryStorage() *MemoryStorage {
+	// nolint:exhaustruct
+	return &MemoryStorage{pending: make(map[Destination][]*Item)}
+}
+
+func (ms *MemoryStorage) Add(webhook string, dest Destination, action string, message slackmessage.SlackMessage,
+	ts string) (*Item, error) {
+	item, err := newItem(webhook, dest, action, message, ts)
+	if err != nil {
+		return nil, err
+	}
+
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	ms.pending[dest] = append(ms.pending[dest], item)
+
+	return ms.copy(item), nil
+}
+
+func (ms *MemoryStorage) Bury(item *Item) error {
+	return ms.Remove(item)
+}
+
+func (ms *MemoryStorage) Destinations() ([]Destination, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	destinations := make([]Destination, 0, len(ms.pending))
+
+	for dest, items := range ms.pending {
+		if len(items) != 0 {
+			destinations = append(destinations, dest)
+		}
+	}
+
+	return destinations, nil
+}
+
+// Pending returns copies of stored messages, so workers can change them
+// without locking.
+func (ms *MemoryStorage) Pending(dest Destination) ([]*Item, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	items := make([]*Item, 0, len(ms.pending[dest]))
+	for _, item := range ms.pending[dest] {
+		items = append(items, ms.copy(item))
+	}
+
+	return items, nil
+}
+
+func (ms *MemoryStorage) PendingCount(dest Destination) (int, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	return len(ms.pending[dest]), nil
+}
+
+func (ms *MemoryStorage) Remove(item *Item) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	dest := item.Destination()
+
+	for idx, stored := range ms.pending[dest] {
+		if stored.ID == item.ID {
+			ms.pending[dest] = append(ms.pending[dest][:idx], ms.pending[dest][idx+1:]...)
+
+			break
+		}
+	}
+
+	if len(ms.pending[dest]) == 0 {
+		delete(ms.pending, dest)
+	}
+
+	return nil
+}
+
+func (ms *MemoryStorage) Update(item *Item) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	for idx, stored := range ms.pending[item.Destination()] {
+		if stored.ID == item.ID {
+			ms.pending[item.Destination()][idx] = ms.copy(item)
+
+			break
+		}
+	}
+
+	return nil
+}
+
+func (ms *MemoryStorage) copy(item *Item) *Item {
+	itemCopy := *item
+
+	return &itemCopy
+}
@@ -0,0 +1,244 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package queue
+
+import (
+	"fmt"
+	"time"
+
+	configstruct "go.dev.pztrn.name/opensaps/config/struct"
+	queueinterface "go.dev.pztrn.name/opensaps/queue/interface"
+	slackmessage "go.dev.pztrn.name/opensaps/slack/message"
+)
+
+// How often we should look for destinations without worker. Such
+// destinations might appear if messages were replayed by another process.
+const scannerInterval = time.Minute
+
+type Queue struct{}
+
+func (q Queue) Enqueue(webhook string, remotes configstruct.ConfigWebhookRemotes, action string,
+	message slackmessage.SlackMessage, ts string) ([]string, error) {
+	dests := make([]Destination, 0, len(remotes))
+	for _, remote := range remotes {
+		dests = append(dests, Destination{Pusher: remote.Pusher, Connection: remote.PushTo})
+	}
+
+	enqueueMutex.Lock()
+
+	// Sender will re-send message if it won't be queued for some
+	// destination, so it shouldn't be queued for others.
+	if err := q.checkCapacity(dests); err != nil {
+		enqueueMutex.Unlock()
+
+		return nil, err
+	}
+
+	items := make([]*Item, 0, len(dests))
+
+	for _, dest := range dests {
+		item, err := storage.Add(webhook, dest, action, message, ts)
+		if err != nil {
+			q.rollback(items)
+			enqueueMutex.Unlock()
+
+			return nil, fmt.Errorf("%w: %s", queueinterface.ErrEnqueueFailed, err.Error())
+		}
+
+		items = append(items, item)
+	}
+
+	enqueueMutex.Unlock()
+
+	ids := make([]string, 0, len(items))
+
+	for _, item := range items {
+		dest := item.Destination()
+
+		ctx.Log.Debug().Str("destination", dest.String()).Str("id", item.ID).Msg("Message queued")
+		reportQueueDepth(dest)
+		q.startWorker(dest, shardForWebhook(webhook)).notify()
+
+		ids = append(ids, item.ID)
+	}
+
+	return ids, nil
+}
+
+func (q Queue) Initialize() {
+	ctx.Log.Info().Msg("Initializing delivery queue...")
+
+	cfg := ctx.Config.GetConfig().Queue
+	directory = cfg.Directory
+	workersCount = cfg.GetWorkers()
+
+	if directory == "" {
+		ctx.Log.Info().Msg("Delivery queue directory isn't configured, messages will be kept in memory")
+
+		storage = NewMemoryStorage()
+	} else {
+		diskStorage, err := NewStorage(directory)
+		if err != nil {
+			ctx.Log.Fatal().Err(err).Str("directory", directory).Msg("Failed to initialize delivery queue storage")
+		}
+
+		diskStorage.OnCorrupt = func(path string, err error) {
+			ctx.Log.Error().Err(err).Str("file", path).Msg("Failed to read queued message, moved it into corrupt files directory")
+		}
+
+		storage = diskStorage
+	}
+
+	q.startWorkersForPending()
+
+	scannerStop = make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(scannerInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-scannerStop:
+				return
+			case <-ticker.C:
+				q.startWorkersForPending()
+			}
+		}
+	}()
+
+	ctx.Log.Info().Str("directory", directory).Int("workers", workersCount).Msg("Delivery queue initialized")
+}
+
+// Delivery settings are read on every attempt, so only storage location
+// and workers count changes should be handled here.
+func (q Queue) Reload() {
+	cfg := ctx.Config.GetConfig().Queue
+
+	if cfg.Directory != directory {
+		ctx.Log.Warn().Str("directory", directory).Str("new_directory", cfg.Directory).
+			Msg("Delivery queue directory can't be changed without restart, will continue to use current one")
+	}
+
+	if cfg.GetWorkers() != workersCount {
+		ctx.Log.Warn().Int("workers", workersCount).Int("new_workers", cfg.GetWorkers()).
+			Msg("Delivery workers count can't be changed without restart, will continue to use current one")
+	}
+}
+
+func (q Queue) Shutdown() {
+	ctx.Log.Info().Msg("Shutting down delivery queue...")
+
+	close(scannerStop)
+
+	workersMutex.Lock()
+	defer workersMutex.Unlock()
+
+	for _, w := range workers {
+		close(w.stop)
+	}
+
+	for _, w := range workers {
+		<-w.done
+	}
+
+	if directory == "" {
+		q.reportLostMessages()
+	}
+
+	ctx.Log.Info().Msg("Delivery queue shutted down")
+}
+
+// Logs messages which will be lost because they're kept in memory.
+func (q Queue) reportLostMessages() {
+	destinations, _ := storage.Destinations()
+
+	for _, dest := range destinations {
+		if pending, _ := storage.PendingCount(dest); pending != 0 {
+			ctx.Log.Warn().Str("destination", dest.String()).Int("messages", pending).
+				Msg("Messages weren't delivered and will be lost, configure queue directory to keep them on restart")
+		}
+	}
+}
+
+// Returns worker for destination's shard, starting it if needed.
+func (q Queue) startWorker(dest Destination, shard int) *worker {
+	workersMutex.Lock()
+	defer workersMutex.Unlock()
+
+	w, found := workers[workerKey(dest, shard)]
+	if !found {
+		w = newWorker(dest, shard)
+		workers[workerKey(dest, shard)] = w
+
+		go w.run()
+	}
+
+	return w
+}
+
+// Checks that every destination can accept one more message. Should be
+// called with enqueue mutex locked.
+func (q Queue) checkCapacity(dests []Destination) error {
+	maxPending := ctx.Config.GetConfig().Queue.GetMaxPending()
+	added := make(map[Destination]int)
+
+	for _, dest := range dests {
+		pending, err := storage.PendingCount(dest)
+		if err != nil {
+			return fmt.Errorf("%w: %s", queueinterface.ErrEnqueueFailed, err.Error())
+		}
+
+		// Same destination might be listed twice.
+		added[dest]++
+
+		if pending+added[dest] > maxPending {
+			return fmt.Errorf("%w: %d messages are waiting for delivery to '%s'", queueinterface.ErrQueueFull,
+				pending, dest.String())
+		}
+	}
+
+	return nil
+}
+
+// Removes messages queued before enqueueing has failed. Should be called
+// with enqueue mutex locked, so workers weren't notified about them yet.
+func (q Queue) rollback(items []*Item) {
+	for _, item := range items {
+		if err := storage.Remove(item); err != nil {
+			ctx.Log.Error().Err(err).Str("destination", item.Destination().String()).Str("id", item.ID).
+				Msg("Failed to remove message queued for other destinations, it will be delivered")
+		}
+	}
+}
+
+// Starts workers for every destination with pending messages.
+func (q Queue) startWorkersForPending() {
+	destinations, err := storage.Destinations()
+	if err != nil {
+		ctx.Log.Error().Err(err).Msg("Failed to get destinations with pending messages")
+
+		return
+	}
+
+	for _, dest := range destinations {
+		for shard := 0; shard < workersCount; shard++ {
+			q.startWorker(dest, shard)
+		}
+	}
+}
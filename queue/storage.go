@@ -0,0 +1,466 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package queue
+
+import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	slackmessage "go.dev.pztrn.name/opensaps/slack/message"
+)
+
+const (
+	// Directory with messages waiting for delivery.
+	pendingDirectory = "pending"
+	// Directory with messages which delivery has failed.
+	deadDirectory = "dead"
+	// Directory with files which can't be read as queued messages.
+	corruptDirectory = "corrupt"
+	// Queued message file extension.
+	itemExtension = ".json"
+)
+
+// ErrItemNotFound returns when there is no queued message with requested ID.
+var ErrItemNotFound = errors.New("queued message not found")
+
+// Destination describes where queued message should be delivered.
+type Destination struct {
+	Pusher     string
+	Connection string
+}
+
+func (d Destination) String() string {
+	return d.Pusher + "/" + d.Connection
+}
+
+// Item is a queued message with it's delivery state. Item's ID is also
+// passed to pushers as delivery ID, e.g. Matrix uses it as transaction ID.
+// nolint:tagliatelle
+type Item struct {
+	CreatedAt   time.Time                 `json:"created_at"`
+	NextAttempt time.Time                 `json:"next_attempt"`
+	Message     slackmessage.SlackMessage `json:"message"`
+	ID          string                    `json:"id"`
+	Webhook     string                    `json:"webhook"`
+	Pusher      string                    `json:"pusher"`
+	Connection  string                    `json:"connection"`
+	LastError   string                    `json:"last_error"`
+	// TS is a timestamp of Slack message sent with Web API.
+	TS string `json:"ts,omitempty"`
+	// Action is what should be done with message, see queueinterface.
+	// Empty for new messages.
+	Action string `json:"action,omitempty"`
+	// SentMessageID is an ID of partially sent message, passed to next
+	// delivery attempt. Empty if nothing was sent yet.
+	SentMessageID string `json:"sent_message_id,omitempty"`
+	Attempts      int    `json:"attempts"`
+}
+
+// Destination returns destination for queued message.
+func (i *Item) Destination() Destination {
+	return Destination{Pusher: i.Pusher, Connection: i.Connection}
+}
+
+// Storage for messages waiting for delivery, used by workers.
+type store interface {
+	// Add puts new message into pending messages list.
+	Add(webhook string, dest Destination, action string, message slackmessage.SlackMessage, ts string) (*Item, error)
+	// Bury moves message into dead messages list.
+	Bury(item *Item) error
+	// Destinations returns all destinations which have messages waiting
+	// for delivery.
+	Destinations() ([]Destination, error)
+	// Pending returns messages waiting for delivery to passed destination
+	// ordered by time they were received.
+	Pending(dest Destination) ([]*Item, error)
+	// PendingCount returns number of messages waiting for delivery to
+	// passed destination.
+	PendingCount(dest Destination) (int, error)
+	// Remove removes delivered message from pending messages list.
+	Remove(item *Item) error
+	// Update saves delivery state of pending message.
+	Update(item *Item) error
+}
+
+// Storage is an on-disk storage for queued messages. Every message is
+// stored in separate file, messages waiting for delivery are placed in
+// "pending/PUSHER/CONNECTION" directories and messages which delivery
+// has failed are placed in "dead" directory. Files which can't be read
+// are moved into "corrupt" directory, so they won't prevent delivery of
+// other messages.
+type Storage struct {
+	// OnCorrupt is called when file was moved into "corrupt" directory.
+	OnCorrupt func(path string, err error)
+	directory string
+	mutex     sync.Mutex
+}
+
+// NewStorage creates storage in passed directory. Directory will be
+// created if it does not exist.
+func NewStorage(directory string) (*Storage, error) {
+	for _, dir := range []string{pendingDirectory, deadDirectory} {
+		// nolint:gomnd
+		if err := os.MkdirAll(filepath.Join(directory, dir), 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create queue directory: %w", err)
+		}
+	}
+
+	// nolint:exhaustruct
+	return &Storage{directory: directory}, nil
+}
+
+// Add puts new message into pending messages list.
+func (s *Storage) Add(webhook string, dest Destination, action string, message slackmessage.SlackMessage,
+	ts string) (*Item, error) {
+	item, err := newItem(webhook, dest, action, message, ts)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.write(s.pendingPath(dest), item); err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
+
+// Bury moves message into dead messages list.
+func (s *Storage) Bury(item *Item) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.write(filepath.Join(s.directory, deadDirectory), item); err != nil {
+		return err
+	}
+
+	return s.remove(s.pendingPath(item.Destination()), item.ID)
+}
+
+// Dead returns all messages which delivery has failed ordered by
+// time they were received.
+func (s *Storage) Dead() ([]*Item, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.readAll(filepath.Join(s.directory, deadDirectory))
+}
+
+// Destinations returns all destinations which have messages waiting
+// for delivery.
+func (s *Storage) Destinations() ([]Destination, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	pushers, err := ioutil.ReadDir(filepath.Join(s.directory, pendingDirectory))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pending messages directory: %w", err)
+	}
+
+	destinations := make([]Destination, 0)
+
+	for _, pusher := range pushers {
+		pusherName, err := url.PathUnescape(pusher.Name())
+		if !pusher.IsDir() || err != nil {
+			continue
+		}
+
+		conns, err := ioutil.ReadDir(filepath.Join(s.directory, pendingDirectory, pusher.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pending messages directory: %w", err)
+		}
+
+		for _, conn := range conns {
+			connName, err := url.PathUnescape(conn.Name())
+			if !conn.IsDir() || err != nil {
+				continue
+			}
+
+			destinations = append(destinations, Destination{Pusher: pusherName, Connection: connName})
+		}
+	}
+
+	return destinations, nil
+}
+
+// GetDead returns dead message with passed ID.
+func (s *Storage) GetDead(id string) (*Item, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.read(filepath.Join(s.directory, deadDirectory), id)
+}
+
+// Pending returns messages waiting for delivery to passed destination
+// ordered by time they were received.
+func (s *Storage) Pending(dest Destination) ([]*Item, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.readAll(s.pendingPath(dest))
+}
+
+// PendingCount returns number of messages waiting for delivery to
+// passed destination.
+func (s *Storage) PendingCount(dest Destination) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	files, err := ioutil.ReadDir(s.pendingPath(dest))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+
+		return 0, fmt.Errorf("failed to read queue directory: %w", err)
+	}
+
+	var count int
+
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), itemExtension) && !strings.HasPrefix(file.Name(), ".") {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// Remove removes delivered message from pending messages list.
+func (s *Storage) Remove(item *Item) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.remove(s.pendingPath(item.Destination()), item.ID)
+}
+
+// Replay moves dead message with passed ID back to pending messages
+// list with clean delivery state.
+func (s *Storage) Replay(id string) (*Item, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	deadPath := filepath.Join(s.directory, deadDirectory)
+
+	item, err := s.read(deadPath, id)
+	if err != nil {
+		return nil, err
+	}
+
+	item.Attempts = 0
+	item.LastError = ""
+	item.NextAttempt = time.Now()
+
+	if err := s.write(s.pendingPath(item.Destination()), item); err != nil {
+		return nil, err
+	}
+
+	return item, s.remove(deadPath, id)
+}
+
+// Update saves delivery state of pending message.
+func (s *Storage) Update(item *Item) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.write(s.pendingPath(item.Destination()), item)
+}
+
+// Creates new message waiting for delivery.
+func newItem(webhook string, dest Destination, action string, message slackmessage.SlackMessage,
+	ts string) (*Item, error) {
+	id, err := generateID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	return &Item{
+		CreatedAt:     now,
+		NextAttempt:   now,
+		Message:       message,
+		ID:            id,
+		Webhook:       webhook,
+		Pusher:        dest.Pusher,
+		Connection:    dest.Connection,
+		LastError:     "",
+		TS:            ts,
+		Action:        action,
+		SentMessageID: "",
+		Attempts:      0,
+	}, nil
+}
+
+// Generates time-ordered message ID, so sorting files by name gives us
+// messages in order they were received.
+func generateID() (string, error) {
+	// nolint:gomnd
+	randomBytes := make([]byte, 4)
+	if _, err := crand.Read(randomBytes); err != nil {
+		return "", fmt.Errorf("failed to generate message ID: %w", err)
+	}
+
+	return fmt.Sprintf("%019d-%s", time.Now().UnixNano(), hex.EncodeToString(randomBytes)), nil
+}
+
+func (s *Storage) pendingPath(dest Destination) string {
+	return filepath.Join(s.directory, pendingDirectory, url.PathEscape(dest.Pusher), url.PathEscape(dest.Connection))
+}
+
+func (s *Storage) read(directory string, id string) (*Item, error) {
+	// IDs are coming from users, so make sure we won't escape directory.
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.HasPrefix(id, ".") {
+		return nil, fmt.Errorf("%w: %s", ErrItemNotFound, id)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(directory, id+itemExtension))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrItemNotFound, id)
+		}
+
+		return nil, fmt.Errorf("failed to read queued message: %w", err)
+	}
+
+	// nolint:exhaustruct
+	item := &Item{}
+	if err := json.Unmarshal(data, item); err != nil {
+		return nil, fmt.Errorf("failed to decode queued message '%s': %w", id, err)
+	}
+
+	return item, nil
+}
+
+func (s *Storage) readAll(directory string) ([]*Item, error) {
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []*Item{}, nil
+		}
+
+		return nil, fmt.Errorf("failed to read queue directory: %w", err)
+	}
+
+	// ioutil.ReadDir returns files sorted by name, but be explicit about it
+	// as delivery order depends on this.
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+
+	items := make([]*Item, 0, len(files))
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), itemExtension) || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+
+		item, err := s.read(directory, strings.TrimSuffix(file.Name(), itemExtension))
+		if err != nil {
+			// Message might be removed after directory was read.
+			if !errors.Is(err, ErrItemNotFound) {
+				s.quarantine(filepath.Join(directory, file.Name()), err)
+			}
+
+			continue
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// Moves file which can't be read into "corrupt" directory.
+func (s *Storage) quarantine(path string, err error) {
+	corruptPath := filepath.Join(s.directory, corruptDirectory)
+
+	// nolint:gomnd
+	if mkdirErr := os.MkdirAll(corruptPath, 0o700); mkdirErr != nil {
+		err = fmt.Errorf("%w (also failed to create directory for corrupt files: %s)", err, mkdirErr.Error())
+	} else if renameErr := os.Rename(path, filepath.Join(corruptPath, filepath.Base(path))); renameErr != nil {
+		err = fmt.Errorf("%w (also failed to move file into corrupt files directory: %s)", err, renameErr.Error())
+	}
+
+	if s.OnCorrupt != nil {
+		s.OnCorrupt(path, err)
+	}
+}
+
+func (s *Storage) remove(directory string, id string) error {
+	err := os.Remove(filepath.Join(directory, id+itemExtension))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove queued message: %w", err)
+	}
+
+	return nil
+}
+
+// Writes message into directory. Message is written into temporary file
+// first and then renamed, so we'll never have partially written messages.
+func (s *Storage) write(directory string, item *Item) error {
+	// nolint:gomnd
+	if err := os.MkdirAll(directory, 0o700); err != nil {
+		return fmt.Errorf("failed to create queue directory: %w", err)
+	}
+
+	data, err := json.Marshal(item)
+	if err != nil {
+		return fmt.Errorf("failed to encode queued message: %w", err)
+	}
+
+	tmpFile, err := ioutil.TempFile(directory, ".tmp-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for queued message: %w", err)
+	}
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+
+		return fmt.Errorf("failed to write queued message: %w", err)
+	}
+
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+
+		return fmt.Errorf("failed to write queued message: %w", err)
+	}
+
+	tmpFile.Close()
+
+	if err := os.Rename(tmpFile.Name(), filepath.Join(directory, item.ID+itemExtension)); err != nil {
+		os.Remove(tmpFile.Name())
+
+		return fmt.Errorf("failed to write queued message: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,247 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package queue
+
+import (
+	"hash/fnv"
+	"strconv"
+	"time"
+
+	messagemapinterface "go.dev.pztrn.name/opensaps/messagemap/interface"
+	pusherinterface "go.dev.pztrn.name/opensaps/pushers/interface"
+	queueinterface "go.dev.pztrn.name/opensaps/queue/interface"
+)
+
+// How often worker will re-read pending messages if it wasn't woken up.
+// This allows to pick up messages replayed by another process.
+const workerRescanInterval = 30 * time.Second
+
+// Worker delivers messages for single destination one by one, so
+// messages will arrive in order they were received. If there are several
+// workers for destination, messages are distributed between them by
+// webhook, so messages from single webhook are still delivered in order.
+type worker struct {
+	destination Destination
+	// Worker delivers only messages which webhooks belong to this shard.
+	shard int
+	// Signals that new message was added.
+	wakeup chan struct{}
+	// Closing this channel stops worker.
+	stop chan struct{}
+	// Will be closed when worker stops.
+	done chan struct{}
+}
+
+func newWorker(dest Destination, shard int) *worker {
+	return &worker{
+		destination: dest,
+		shard:       shard,
+		wakeup:      make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// Notifies worker about new message. Never blocks.
+func (w *worker) notify() {
+	select {
+	case w.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+func (w *worker) run() {
+	defer close(w.done)
+
+	ctx.Log.Debug().Str("destination", w.destination.String()).Int("shard", w.shard).Msg("Starting delivery worker")
+
+	for {
+		items, err := storage.Pending(w.destination)
+		if err != nil {
+			ctx.Log.Error().Err(err).Str("destination", w.destination.String()).Msg("Failed to get pending messages")
+		}
+
+		reportQueueDepth(w.destination)
+
+		for _, item := range items {
+			if shardForWebhook(item.Webhook) != w.shard {
+				continue
+			}
+
+			if !w.deliver(item) {
+				return
+			}
+		}
+
+		select {
+		case <-w.stop:
+			return
+		case <-w.wakeup:
+		case <-time.After(workerRescanInterval):
+		}
+	}
+}
+
+// Sends, updates or deletes message. Returns ID of sent message. Queued
+// message ID is passed to pushers as delivery ID, so remote service
+// won't create duplicates if previous attempt has succeeded after all.
+func (w *worker) perform(item *Item) (string, error) {
+	if item.Action == queueinterface.ActionPost {
+		return ctx.SendToPusher(item.Pusher, item.Connection, item.Message, item.ID, item.SentMessageID)
+	}
+
+	// Message might not be sent to this destination, e.g. if it's
+	// delivery has failed or if it was empty.
+	entry, _ := ctx.MessageMap.Get(item.TS)
+
+	messageID := entry.MessageID(item.Pusher, item.Connection)
+	if messageID == "" {
+		ctx.Log.Warn().Str("destination", w.destination.String()).Str("id", item.ID).Str("ts", item.TS).
+			Str("action", item.Action).Msg("Message wasn't sent to destination, nothing to change")
+
+		return "", nil
+	}
+
+	if item.Action == queueinterface.ActionDelete {
+		return "", ctx.DeleteFromPusher(item.Pusher, item.Connection, messageID, item.ID)
+	}
+
+	return "", ctx.UpdateInPusher(item.Pusher, item.Connection, messageID, item.Message, item.ID)
+}
+
+// Tries to deliver message until it will be delivered or buried.
+// Returns false if worker was stopped while waiting for next attempt.
+func (w *worker) deliver(item *Item) bool {
+	log := ctx.Log.With().Str("destination", w.destination.String()).Str("id", item.ID).Logger()
+
+	for {
+		if wait := time.Until(item.NextAttempt); wait > 0 {
+			log.Debug().Dur("wait", wait).Msg("Waiting before next delivery attempt")
+
+			select {
+			case <-w.stop:
+				return false
+			case <-time.After(wait):
+			}
+		}
+
+		messageID, err := w.perform(item)
+		item.Attempts++
+
+		if err == nil {
+			log.Info().Int("attempts", item.Attempts).Msg("Queued message delivered")
+
+			// Web API messages might be updated or deleted later.
+			if item.Action == queueinterface.ActionPost && item.TS != "" && messageID != "" {
+				ctx.MessageMap.Add(item.TS, messagemapinterface.RemoteMessage{
+					Pusher:     item.Pusher,
+					Connection: item.Connection,
+					ID:         messageID,
+				})
+			}
+
+			if err := storage.Remove(item); err != nil {
+				log.Error().Err(err).Msg("Failed to remove delivered message from queue")
+			}
+
+			reportQueueDepth(w.destination)
+
+			return true
+		}
+
+		item.LastError = ctx.Redact(err.Error())
+
+		// Message might be sent partially, next attempt should continue
+		// it instead of sending it again.
+		if sentMessageID := pusherinterface.GetSentMessageID(err); sentMessageID != "" {
+			item.SentMessageID = sentMessageID
+		}
+
+		cfg := ctx.Config.GetConfig().Queue
+		if !pusherinterface.IsTemporary(err) || item.Attempts >= cfg.GetMaxAttempts() {
+			log.Error().Err(err).Int("attempts", item.Attempts).Msg("Giving up delivering message, moving it to dead letters")
+
+			if err := storage.Bury(item); err != nil {
+				log.Error().Err(err).Msg("Failed to move message to dead letters")
+			}
+
+			reportQueueDepth(w.destination)
+
+			return true
+		}
+
+		// Remote service might ask us to wait longer than we would.
+		delay := backoff(item.Attempts, cfg.GetInitialBackoff(), cfg.GetMaxBackoff())
+		if retryAfter := pusherinterface.GetRetryAfter(err); retryAfter > delay {
+			delay = retryAfter
+		}
+
+		item.NextAttempt = time.Now().Add(delay)
+
+		log.Warn().Err(err).Int("attempts", item.Attempts).Time("next_attempt", item.NextAttempt).
+			Msg("Failed to deliver queued message, will retry later")
+
+		if err := storage.Update(item); err != nil {
+			log.Error().Err(err).Msg("Failed to save queued message state")
+		}
+	}
+}
+
+// Returns key for workers map.
+func workerKey(dest Destination, shard int) string {
+	return dest.String() + "#" + strconv.Itoa(shard)
+}
+
+// Returns worker's shard which delivers messages from passed webhook.
+func shardForWebhook(webhook string) int {
+	if workersCount <= 1 {
+		return 0
+	}
+
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(webhook))
+
+	return int(hash.Sum32() % uint32(workersCount))
+}
+
+// Updates queue depth metric for destination.
+func reportQueueDepth(dest Destination) {
+	depth, err := storage.PendingCount(dest)
+	if err != nil {
+		ctx.Log.Error().Err(err).Str("destination", dest.String()).Msg("Failed to count pending messages")
+
+		return
+	}
+
+	ctx.Metrics.QueueDepth(dest.Pusher, dest.Connection, depth)
+}
+
+// Calculates delay before next delivery attempt. Delay doubles with
+// every failed attempt until it reaches maximum.
+func backoff(attempts int, initial time.Duration, maximum time.Duration) time.Duration {
+	delay := initial
+
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= maximum {
+			return maximum
+		}
+	}
+
+	return delay
+}
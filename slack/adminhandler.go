@@ -0,0 +1,135 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package slack
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync/atomic"
+
+	pusherinterface "go.dev.pztrn.name/opensaps/pushers/interface"
+)
+
+// Administrative endpoints paths.
+const (
+	healthPath    = "/healthz"
+	metricsPath   = "/metrics"
+	readinessPath = "/readyz"
+)
+
+// Reply for readiness endpoint.
+type readinessReply struct {
+	Status       string                             `json:"status"`
+	Connections  []pusherinterface.ConnectionStatus `json:"connections,omitempty"`
+	ConfigLoaded bool                               `json:"config_loaded"`
+	ListenerUp   bool                               `json:"listener_up"`
+}
+
+// AdminHandler serves administrative endpoints. It is used either by
+// separate admin listener or by Slack Webhooks API listener if admin
+// listener wasn't configured.
+type AdminHandler struct{}
+
+// Handles returns true if request should be served by admin handler.
+func (ah AdminHandler) Handles(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+
+	return req.URL.Path == healthPath || req.URL.Path == metricsPath || req.URL.Path == readinessPath
+}
+
+func (ah AdminHandler) ServeHTTP(respwriter http.ResponseWriter, req *http.Request) {
+	if !ah.Handles(req) {
+		respwriter.WriteHeader(http.StatusNotFound)
+		_, _ = respwriter.Write([]byte("NOT FOUND"))
+
+		return
+	}
+
+	switch req.URL.Path {
+	case healthPath:
+		// If we're able to reply - we're alive.
+		ah.writeJSON(respwriter, http.StatusOK, map[string]string{"status": "ok"})
+	case metricsPath:
+		respwriter.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		if err := ctx.Metrics.WriteMetrics(respwriter); err != nil {
+			ctx.Log.Error().Err(err).Msg("Failed to write metrics")
+		}
+	case readinessPath:
+		reply := ah.readiness()
+
+		status := http.StatusOK
+		if reply.Status != "ok" {
+			status = http.StatusServiceUnavailable
+		}
+
+		ah.writeJSON(respwriter, status, reply)
+	}
+}
+
+// Checks if we're ready to receive and deliver messages: configuration
+// is loaded, Slack Webhooks API listener is up and every connection is
+// able to deliver messages.
+func (ah AdminHandler) readiness() readinessReply {
+	reply := readinessReply{
+		Status:       "ok",
+		Connections:  []pusherinterface.ConnectionStatus{},
+		ConfigLoaded: ctx.Config.GetConfig() != nil,
+		ListenerUp:   atomic.LoadInt32(&listening) == 1,
+	}
+
+	pushers := make([]string, 0, len(ctx.Pushers))
+	for name := range ctx.Pushers {
+		pushers = append(pushers, name)
+	}
+
+	sort.Strings(pushers)
+
+	for _, name := range pushers {
+		reply.Connections = append(reply.Connections, ctx.Pushers[name].Status()...)
+	}
+
+	ready := reply.ConfigLoaded && reply.ListenerUp
+
+	for _, conn := range reply.Connections {
+		ready = ready && conn.Ready
+	}
+
+	if !ready {
+		reply.Status = "fail"
+	}
+
+	return reply
+}
+
+func (ah AdminHandler) writeJSON(respwriter http.ResponseWriter, status int, data interface{}) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		ctx.Log.Error().Err(err).Msg("Failed to encode reply")
+		respwriter.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	respwriter.Header().Set("Content-Type", "application/json")
+	respwriter.WriteHeader(status)
+	_, _ = respwriter.Write(body)
+}
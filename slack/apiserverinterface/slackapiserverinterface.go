@@ -19,5 +19,6 @@ package slackapiserverinterface
 
 type SlackAPIServerInterface interface {
 	Initialize()
+	Reload()
 	Shutdown()
 }
@@ -19,6 +19,7 @@ package slack
 
 import (
 	"net/http"
+	"sync"
 
 	"go.dev.pztrn.name/opensaps/context"
 	slackapiserverinterface "go.dev.pztrn.name/opensaps/slack/apiserverinterface"
@@ -27,7 +28,16 @@ import (
 var (
 	ctx *context.Context
 	// HTTP server.
-	httpsrv *http.Server
+	httpsrv      *http.Server
+	httpsrvMutex sync.Mutex
+	// HTTP server for administrative endpoints, nil if separate listener
+	// wasn't configured. Protected by httpsrvMutex.
+	adminsrv *http.Server
+	// Set to 1 when Slack Webhooks API server is listening. Atomic, as
+	// httpsrvMutex can be held while waiting for in-flight requests.
+	listening int32
+	// Last generated Slack message timestamp, in microseconds.
+	lastTS int64
 )
 
 func New(cc *context.Context) {
@@ -0,0 +1,152 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package slackmessage
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Block is a Block Kit layout block. Which fields are used depends on
+// block's type, see https://api.slack.com/reference/block-kit/blocks.
+// nolint:tagliatelle
+type Block struct {
+	// Text for section and header blocks.
+	Text *TextObject `json:"text,omitempty"`
+	// Title for image and video blocks.
+	Title *TextObject `json:"title,omitempty"`
+	// Accessory is an element displayed next to section's text.
+	Accessory *BlockElement `json:"accessory,omitempty"`
+	Type      string        `json:"type"`
+	BlockID   string        `json:"block_id,omitempty"`
+	// Image (and video thumbnail) URL with alternative text.
+	ImageURL string `json:"image_url,omitempty"`
+	AltText  string `json:"alt_text,omitempty"`
+	// Video's URL.
+	TitleURL string `json:"title_url,omitempty"`
+	// Fields for section blocks.
+	Fields []TextObject `json:"fields,omitempty"`
+	// Elements for context, actions and rich text blocks.
+	Elements []BlockElement `json:"elements,omitempty"`
+}
+
+// BlockElement is an element of block: text object, image, interactive
+// element or rich text element.
+// nolint:tagliatelle
+type BlockElement struct {
+	// Placeholder for selects and inputs.
+	Placeholder *TextObject `json:"placeholder,omitempty"`
+	// Text is a label for buttons, a text for text objects and rich
+	// text elements.
+	Text TextObject `json:"text"`
+	// Style is a button style, rich text list style or rich text
+	// formatting.
+	Style    RichTextStyle `json:"style"`
+	Type     string        `json:"type"`
+	URL      string        `json:"url,omitempty"`
+	ImageURL string        `json:"image_url,omitempty"`
+	AltText  string        `json:"alt_text,omitempty"`
+	// Mentions in rich text.
+	UserID      string `json:"user_id,omitempty"`
+	ChannelID   string `json:"channel_id,omitempty"`
+	UsergroupID string `json:"usergroup_id,omitempty"`
+	// Range is a broadcast range, like "here" or "channel".
+	Range string `json:"range,omitempty"`
+	// Name is an emoji name.
+	Name string `json:"name,omitempty"`
+	// Elements for rich text sections, lists, quotes and preformatted
+	// texts.
+	Elements []BlockElement `json:"elements,omitempty"`
+	// Indent is a rich text list indentation level.
+	Indent int `json:"indent,omitempty"`
+}
+
+// TextObject is a Block Kit text object. Rich text elements pass text
+// as plain string, it is decoded into Text.
+type TextObject struct {
+	// Type is "plain_text" or "mrkdwn".
+	Type string `json:"type"`
+	Text string `json:"text"`
+	// Verbatim disables links and mentions auto-detection.
+	Verbatim bool `json:"verbatim,omitempty"`
+}
+
+func (to *TextObject) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+		*to = TextObject{Type: "plain_text", Text: "", Verbatim: false}
+
+		return json.Unmarshal(data, &to.Text)
+	}
+
+	// Avoid recursion.
+	type textObject TextObject
+
+	var decoded textObject
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	*to = TextObject(decoded)
+
+	return nil
+}
+
+// IsMrkdwn returns true if text should be formatted with mrkdwn.
+func (to *TextObject) IsMrkdwn() bool {
+	return to.Type == "mrkdwn"
+}
+
+// RichTextStyle is either a string (button style or rich text list style
+// like "bullet") or a rich text formatting object.
+type RichTextStyle struct {
+	Name   string
+	Bold   bool
+	Italic bool
+	Strike bool
+	Code   bool
+}
+
+func (rts RichTextStyle) MarshalJSON() ([]byte, error) {
+	if rts.Name != "" {
+		return json.Marshal(rts.Name)
+	}
+
+	return json.Marshal(map[string]bool{"bold": rts.Bold, "italic": rts.Italic, "strike": rts.Strike, "code": rts.Code})
+}
+
+func (rts *RichTextStyle) UnmarshalJSON(data []byte) error {
+	// nolint:exhaustruct
+	*rts = RichTextStyle{}
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+		return json.Unmarshal(data, &rts.Name)
+	}
+
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		return nil
+	}
+
+	flags := make(map[string]bool)
+	if err := json.Unmarshal(data, &flags); err != nil {
+		return err
+	}
+
+	rts.Bold, rts.Italic, rts.Strike, rts.Code = flags["bold"], flags["italic"], flags["strike"], flags["code"]
+
+	return nil
+}
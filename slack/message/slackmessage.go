@@ -17,6 +17,15 @@
 // along with this program.  If not, see <http://www.gnu.org/licenses/>.
 package slackmessage
 
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
 // nolint:tagliatelle
 type SlackMessage struct {
 	Channel     string             `json:"channel"`
@@ -24,13 +33,107 @@ type SlackMessage struct {
 	Username    string             `json:"username"`
 	IconURL     string             `json:"icon_url"`
 	Attachments []SlackAttachments `json:"attachments"`
-	UnfurlLinks int                `json:"unfurl_links"`
-	LinkNames   int                `json:"link_names"`
+	// Blocks are Block Kit blocks. If present - Text is only used for
+	// notifications.
+	Blocks []Block `json:"blocks,omitempty"`
+	// ThreadTS is a timestamp of message this message replies to.
+	ThreadTS    string `json:"thread_ts,omitempty"`
+	UnfurlLinks int    `json:"unfurl_links"`
+	LinkNames   int    `json:"link_names"`
 }
 
+// SlackAttachments is a legacy Slack message attachment.
+// nolint:tagliatelle
 type SlackAttachments struct {
-	Fallback string `json:"fallback"`
-	Color    string `json:"color"`
-	Title    string `json:"title"`
-	Text     string `json:"text"`
+	Timestamp  Timestamp              `json:"ts"`
+	Fallback   string                 `json:"fallback"`
+	Color      string                 `json:"color"`
+	Pretext    string                 `json:"pretext"`
+	AuthorName string                 `json:"author_name"`
+	AuthorLink string                 `json:"author_link"`
+	AuthorIcon string                 `json:"author_icon"`
+	Title      string                 `json:"title"`
+	TitleLink  string                 `json:"title_link"`
+	Text       string                 `json:"text"`
+	ImageURL   string                 `json:"image_url"`
+	ThumbURL   string                 `json:"thumb_url"`
+	Footer     string                 `json:"footer"`
+	FooterIcon string                 `json:"footer_icon"`
+	Fields     []SlackAttachmentField `json:"fields"`
+	// Blocks are Block Kit blocks displayed after attachment's text.
+	Blocks []Block `json:"blocks,omitempty"`
+	// MrkdwnIn lists fields ("pretext", "text", "fields") which should be
+	// formatted with mrkdwn. If not set - all of them are formatted.
+	MrkdwnIn []string `json:"mrkdwn_in"`
+}
+
+// IsMrkdwn returns true if passed field should be formatted with mrkdwn.
+func (sa SlackAttachments) IsMrkdwn(field string) bool {
+	if sa.MrkdwnIn == nil {
+		return true
+	}
+
+	for _, name := range sa.MrkdwnIn {
+		if name == field {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SlackAttachmentField is a titled value displayed in attachment's table.
+type SlackAttachmentField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+// Timestamp is an Unix timestamp which Slack clients send either as
+// number (possibly with fractional part) or as string.
+type Timestamp struct {
+	time.Time
+}
+
+func (ts Timestamp) MarshalJSON() ([]byte, error) {
+	if ts.IsZero() {
+		return []byte("0"), nil
+	}
+
+	// nolint:gomnd
+	return []byte(strconv.FormatFloat(float64(ts.UnixNano())/1e9, 'f', -1, 64)), nil
+}
+
+func (ts *Timestamp) UnmarshalJSON(data []byte) error {
+	ts.Time = time.Time{}
+
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		value = string(data)
+	}
+
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+
+	// nolint:gomnd
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return err
+	}
+
+	if seconds <= 0 {
+		return nil
+	}
+
+	whole, fraction := math.Modf(seconds)
+	// nolint:gomnd
+	ts.Time = time.Unix(int64(whole), int64(fraction*1e9))
+
+	return nil
 }
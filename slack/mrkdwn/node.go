@@ -0,0 +1,77 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package mrkdwn
+
+// NodeType is a type of mrkdwn AST node.
+type NodeType int
+
+const (
+	// NodeText is a plain text.
+	NodeText NodeType = iota
+	// NodeBold is a *bold* text, see Children.
+	NodeBold
+	// NodeItalic is an _italic_ text, see Children.
+	NodeItalic
+	// NodeStrike is a ~striked~ text, see Children.
+	NodeStrike
+	// NodeCode is an `inline code`, see Text.
+	NodeCode
+	// NodeCodeBlock is a ```code block```, see Text.
+	NodeCodeBlock
+	// NodeQuote is a "> quote", see Children.
+	NodeQuote
+	// NodeLink is a link to URL with Children as label.
+	NodeLink
+	// NodeMention is a user, channel or group mention (like "@here"),
+	// see Text.
+	NodeMention
+	// NodeLineBreak is a line break.
+	NodeLineBreak
+)
+
+// Node is a node of mrkdwn AST.
+type Node struct {
+	// Children for formatting nodes, quotes and links.
+	Children []*Node
+	// Text for text, code and mention nodes.
+	Text string
+	// URL for links.
+	URL  string
+	Type NodeType
+}
+
+// IsBlock returns true for nodes which should be rendered on separate
+// lines, like code blocks and quotes.
+func (n *Node) IsBlock() bool {
+	return n.Type == NodeCodeBlock || n.Type == NodeQuote
+}
+
+// Links returns all link nodes found in passed nodes and their children.
+func Links(nodes []*Node) []*Node {
+	var links []*Node
+
+	for _, node := range nodes {
+		if node.Type == NodeLink {
+			links = append(links, node)
+		}
+
+		links = append(links, Links(node.Children)...)
+	}
+
+	return links
+}
@@ -0,0 +1,427 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package mrkdwn
+
+// Slack's mrkdwn isn't a Markdown and has no formal specification. Parser
+// follows Slack's documentation and behaviour:
+//   * Formatting (*bold*, _italic_, ~strike~) should start at word
+//     boundary and cannot span multiple lines.
+//   * `code` and ```code blocks``` are never formatted.
+//   * Lines starting with ">" are quotes, ">>>" quotes everything after it.
+//   * Links, mentions and special commands are enclosed in "<>".
+//   * "&", "<" and ">" in text are escaped as HTML entities.
+
+import (
+	"net/url"
+	"strings"
+)
+
+const codeBlockFence = "```"
+
+// Format describes how text should be parsed.
+type Format int
+
+const (
+	// FormatMrkdwn is a text with full mrkdwn formatting.
+	FormatMrkdwn Format = iota
+	// FormatLinks is a text where only links, mentions and line breaks
+	// are recognized, like legacy attachment fields not listed in
+	// "mrkdwn_in".
+	FormatLinks
+	// FormatPlain is a text which should be displayed as is.
+	FormatPlain
+)
+
+// nolint:gochecknoglobals
+var (
+	entitiesDecoder = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&")
+	markerTypes     = map[byte]NodeType{'*': NodeBold, '_': NodeItalic, '~': NodeStrike}
+)
+
+// Escape escapes text so it could be safely used in mrkdwn links and
+// mentions.
+func Escape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// IsSafeURL returns true if URL can be used in links sent to chats.
+// URLs with schemes like "javascript:" or "data:" might execute code
+// in web clients, so only well-known schemes are allowed.
+func IsSafeURL(link string) bool {
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+
+	switch strings.ToLower(parsed.Scheme) {
+	case "http", "https", "ftp", "mailto", "magnet":
+		return true
+	default:
+		return false
+	}
+}
+
+// Link returns mrkdwn link to URL with passed plain text label.
+func Link(url string, label string) string {
+	if label == "" {
+		return "<" + Escape(url) + ">"
+	}
+
+	return "<" + Escape(url) + "|" + Escape(label) + ">"
+}
+
+// ParseAs parses text in passed format into list of nodes.
+func ParseAs(text string, format Format) []*Node {
+	switch format {
+	case FormatLinks:
+		return parseInline(strings.ReplaceAll(text, "\r\n", "\n"), false)
+	case FormatPlain:
+		nodes := make([]*Node, 0)
+
+		for idx, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+			if idx != 0 {
+				// nolint:exhaustruct
+				nodes = append(nodes, &Node{Type: NodeLineBreak})
+			}
+
+			if line != "" {
+				// nolint:exhaustruct
+				nodes = append(nodes, &Node{Type: NodeText, Text: line})
+			}
+		}
+
+		return nodes
+	default:
+		return Parse(text)
+	}
+}
+
+// Parse parses mrkdwn text into list of nodes.
+func Parse(text string) []*Node {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	nodes := make([]*Node, 0)
+
+	for {
+		start := strings.Index(text, codeBlockFence)
+		if start == -1 {
+			break
+		}
+
+		end := strings.Index(text[start+len(codeBlockFence):], codeBlockFence)
+		if end == -1 {
+			break
+		}
+
+		end += start + len(codeBlockFence)
+
+		// Code block is a block itself, so line breaks around it aren't
+		// needed.
+		nodes = append(nodes, parseLines(strings.TrimSuffix(text[:start], "\n"))...)
+
+		code := text[start+len(codeBlockFence) : end]
+		code = strings.TrimSuffix(strings.TrimPrefix(code, "\n"), "\n")
+
+		// nolint:exhaustruct
+		nodes = append(nodes, &Node{Type: NodeCodeBlock, Text: entitiesDecoder.Replace(code)})
+
+		text = strings.TrimPrefix(text[end+len(codeBlockFence):], "\n")
+	}
+
+	return append(nodes, parseLines(text)...)
+}
+
+// Parses text without code blocks line by line, grouping quoted lines.
+func parseLines(text string) []*Node {
+	if text == "" {
+		return nil
+	}
+
+	var (
+		nodes  []*Node
+		quoted []string
+		// Previous line was a regular line, so line break is needed.
+		prevInline bool
+	)
+
+	lines := strings.Split(text, "\n")
+
+	for idx, line := range lines {
+		if rest, found := cutQuoteMarker(line, ">>>", "&gt;&gt;&gt;"); found {
+			quoted = append(quoted, rest)
+			quoted = append(quoted, lines[idx+1:]...)
+
+			break
+		}
+
+		if rest, found := cutQuoteMarker(line, ">", "&gt;"); found {
+			quoted = append(quoted, rest)
+			prevInline = false
+
+			continue
+		}
+
+		if len(quoted) != 0 {
+			nodes = append(nodes, newQuote(quoted))
+			quoted = nil
+		}
+
+		if prevInline {
+			// nolint:exhaustruct
+			nodes = append(nodes, &Node{Type: NodeLineBreak})
+		}
+
+		nodes = append(nodes, parseInline(line, true)...)
+		prevInline = true
+	}
+
+	if len(quoted) != 0 {
+		nodes = append(nodes, newQuote(quoted))
+	}
+
+	return nodes
+}
+
+// Parses text inside line (or quote). If formatting is false - only
+// links, mentions and line breaks will be recognized.
+func parseInline(text string, formatting bool) []*Node {
+	var (
+		nodes []*Node
+		buf   strings.Builder
+	)
+
+	flush := func() {
+		if buf.Len() != 0 {
+			// nolint:exhaustruct
+			nodes = append(nodes, &Node{Type: NodeText, Text: entitiesDecoder.Replace(buf.String())})
+			buf.Reset()
+		}
+	}
+
+	for idx := 0; idx < len(text); idx++ {
+		switch text[idx] {
+		case '\n':
+			flush()
+
+			// nolint:exhaustruct
+			nodes = append(nodes, &Node{Type: NodeLineBreak})
+
+			continue
+		case '`':
+			if end := closingBacktick(text, idx); formatting && end != -1 {
+				flush()
+
+				// nolint:exhaustruct
+				nodes = append(nodes, &Node{Type: NodeCode, Text: entitiesDecoder.Replace(text[idx+1 : end])})
+				idx = end
+
+				continue
+			}
+		case '<':
+			if end := closingAngle(text, idx); end != -1 {
+				if node := parseAngle(text[idx+1:end], formatting); node != nil {
+					flush()
+
+					nodes = append(nodes, node)
+					idx = end
+
+					continue
+				}
+			}
+		case '*', '_', '~':
+			if end := closingMarker(text, idx); formatting && end != -1 {
+				flush()
+
+				// nolint:exhaustruct
+				nodes = append(nodes, &Node{Type: markerTypes[text[idx]], Children: parseInline(text[idx+1:end], true)})
+				idx = end
+
+				continue
+			}
+		}
+
+		buf.WriteByte(text[idx])
+	}
+
+	flush()
+
+	return nodes
+}
+
+// Parses links, mentions and special commands (content of "<>").
+// Returns nil if content doesn't look like any of them.
+func parseAngle(content string, formatting bool) *Node {
+	target, label := content, ""
+	if idx := strings.IndexByte(content, '|'); idx != -1 {
+		target, label = content[:idx], content[idx+1:]
+	}
+
+	switch {
+	case strings.HasPrefix(target, "@"):
+		return newMention("@", target[1:], label)
+	case strings.HasPrefix(target, "#"):
+		return newMention("#", target[1:], label)
+	case strings.HasPrefix(target, "!"):
+		return parseSpecial(target[1:], label)
+	}
+
+	link := entitiesDecoder.Replace(target)
+
+	// Things like "Vec<T>" shouldn't become links.
+	parsed, err := url.Parse(link)
+	if err != nil || parsed.Scheme == "" || strings.ContainsAny(link, " \t") {
+		return nil
+	}
+
+	// nolint:exhaustruct
+	node := &Node{Type: NodeLink, URL: link, Children: parseInline(label, formatting)}
+	if label == "" {
+		// nolint:exhaustruct
+		node.Children = []*Node{{Type: NodeText, Text: strings.TrimPrefix(link, "mailto:")}}
+	}
+
+	return node
+}
+
+// Parses special commands like "<!here>", "<!subteam^ID|@team>" or
+// "<!date^1392734382^{date}|Feb 18, 2014>".
+func parseSpecial(target string, label string) *Node {
+	command, argument := target, ""
+	if idx := strings.IndexByte(target, '^'); idx != -1 {
+		command, argument = target[:idx], target[idx+1:]
+	}
+
+	switch command {
+	case "date":
+		// Label is a fallback text, we can't format dates like Slack
+		// does.
+		// nolint:exhaustruct
+		return &Node{Type: NodeText, Text: entitiesDecoder.Replace(label)}
+	case "subteam":
+		return newMention("@", argument, label)
+	default:
+		return newMention("@", command, label)
+	}
+}
+
+// Returns position of closing backtick for inline code or -1.
+func closingBacktick(text string, start int) int {
+	end := strings.IndexByte(text[start+1:], '`')
+	if end <= 0 || strings.ContainsRune(text[start+1:start+1+end], '\n') {
+		return -1
+	}
+
+	return start + 1 + end
+}
+
+// Returns position of closing angle bracket or -1.
+func closingAngle(text string, start int) int {
+	for idx := start + 1; idx < len(text); idx++ {
+		switch text[idx] {
+		case '<', '\n':
+			return -1
+		case '>':
+			if idx == start+1 {
+				return -1
+			}
+
+			return idx
+		}
+	}
+
+	return -1
+}
+
+// Returns position of closing formatting marker or -1.
+func closingMarker(text string, start int) int {
+	marker := text[start]
+
+	// Formatting should start at word boundary and text shouldn't start
+	// with whitespace, e.g. "snake_case" or "2 * 2" aren't formatted.
+	if start > 0 && isWordByte(text[start-1]) {
+		return -1
+	}
+
+	if start+1 >= len(text) || isSpaceByte(text[start+1]) || text[start+1] == marker {
+		return -1
+	}
+
+	for idx := start + 1; idx < len(text); idx++ {
+		switch text[idx] {
+		case '\n':
+			return -1
+		case '`':
+			// Markers inside code and links aren't closing ones.
+			if end := closingBacktick(text, idx); end != -1 {
+				idx = end
+			}
+		case '<':
+			if end := closingAngle(text, idx); end != -1 {
+				idx = end
+			}
+		case marker:
+			if isSpaceByte(text[idx-1]) || (idx+1 < len(text) && isWordByte(text[idx+1])) {
+				continue
+			}
+
+			return idx
+		}
+	}
+
+	return -1
+}
+
+// Checks if line starts with one of quote markers and returns line
+// without it.
+func cutQuoteMarker(line string, markers ...string) (string, bool) {
+	for _, marker := range markers {
+		if strings.HasPrefix(line, marker) {
+			return strings.TrimPrefix(line[len(marker):], " "), true
+		}
+	}
+
+	return line, false
+}
+
+// Bytes of multibyte UTF-8 characters are considered to be letters.
+func isWordByte(char byte) bool {
+	return char >= 0x80 || (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || (char >= '0' && char <= '9')
+}
+
+func isSpaceByte(char byte) bool {
+	return char == ' ' || char == '\t' || char == '\n'
+}
+
+func newMention(prefix string, id string, label string) *Node {
+	name := id
+	if label != "" {
+		name = label
+	}
+
+	name = entitiesDecoder.Replace(name)
+	if !strings.HasPrefix(name, prefix) {
+		name = prefix + name
+	}
+
+	// nolint:exhaustruct
+	return &Node{Type: NodeMention, Text: name}
+}
+
+func newQuote(lines []string) *Node {
+	// nolint:exhaustruct
+	return &Node{Type: NodeQuote, Children: parseInline(strings.Join(lines, "\n"), true)}
+}
@@ -0,0 +1,261 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package mrkdwn
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Returns compact representation of nodes, e.g. `b("bold") br "text"`.
+func dump(nodes []*Node) string {
+	names := map[NodeType]string{
+		NodeBold:      "b",
+		NodeItalic:    "i",
+		NodeStrike:    "s",
+		NodeCode:      "code",
+		NodeCodeBlock: "pre",
+		NodeQuote:     "quote",
+		NodeMention:   "mention",
+	}
+
+	result := make([]string, 0, len(nodes))
+
+	for _, node := range nodes {
+		switch node.Type {
+		case NodeText:
+			result = append(result, strconv.Quote(node.Text))
+		case NodeLineBreak:
+			result = append(result, "br")
+		case NodeCode, NodeCodeBlock, NodeMention:
+			result = append(result, names[node.Type]+"("+strconv.Quote(node.Text)+")")
+		case NodeLink:
+			result = append(result, "link("+strconv.Quote(node.URL)+", "+dump(node.Children)+")")
+		default:
+			result = append(result, names[node.Type]+"("+dump(node.Children)+")")
+		}
+	}
+
+	return strings.Join(result, " ")
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "plain text",
+			text: "just text",
+			want: `"just text"`,
+		},
+		{
+			name: "formatting",
+			text: "*bold* _italic_ ~strike~",
+			want: `b("bold") " " i("italic") " " s("strike")`,
+		},
+		{
+			name: "nested formatting",
+			text: "*bold _italic_*",
+			want: `b("bold " i("italic"))`,
+		},
+		{
+			name: "formatting inside words",
+			text: "snake_case_name and 2 * 2 * 2",
+			want: `"snake_case_name and 2 * 2 * 2"`,
+		},
+		{
+			name: "formatting spans lines",
+			text: "*not\nbold*",
+			want: `"*not" br "bold*"`,
+		},
+		{
+			name: "inline code",
+			text: "run `*make*` now",
+			want: `"run " code("*make*") " now"`,
+		},
+		{
+			name: "code block",
+			text: "before\n```\n*code* &lt;\n```\nafter",
+			want: `"before" pre("*code* <") "after"`,
+		},
+		{
+			name: "unclosed code block",
+			text: "```code",
+			want: `"` + "```" + `code"`,
+		},
+		{
+			name: "quote",
+			text: "&gt; quoted\n&gt; *lines*\ntext",
+			want: `quote("quoted" br b("lines")) "text"`,
+		},
+		{
+			name: "quote till the end",
+			text: "text\n>>> quoted\nlines",
+			want: `"text" quote("quoted" br "lines")`,
+		},
+		{
+			name: "link",
+			text: "<https://example.com|*site*>",
+			want: `link("https://example.com", b("site"))`,
+		},
+		{
+			name: "link without label",
+			text: "<mailto:user@example.com>",
+			want: `link("mailto:user@example.com", "user@example.com")`,
+		},
+		{
+			name: "link with entities",
+			text: "<https://example.com/?a=1&amp;b=2>",
+			want: `link("https://example.com/?a=1&b=2", "https://example.com/?a=1&b=2")`,
+		},
+		{
+			name: "angle brackets aren't a link",
+			text: "Vec&lt;T&gt; <not a link>",
+			want: `"Vec<T> <not a link>"`,
+		},
+		{
+			name: "mentions",
+			text: "<@U123|user> <#C123|general> <!here> <!subteam^S123|@team>",
+			want: `mention("@user") " " mention("#general") " " mention("@here") " " mention("@team")`,
+		},
+		{
+			name: "date",
+			text: "<!date^1392734382^{date}|Feb 18, 2014>",
+			want: `"Feb 18, 2014"`,
+		},
+		{
+			name: "windows line breaks",
+			text: "first\r\nsecond",
+			want: `"first" br "second"`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			if got := dump(Parse(test.text)); got != test.want {
+				t.Fatalf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseAs(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		format Format
+		want   string
+	}{
+		{
+			name:   "mrkdwn",
+			text:   "*bold* <https://example.com|site>",
+			format: FormatMrkdwn,
+			want:   `b("bold") " " link("https://example.com", "site")`,
+		},
+		{
+			name:   "links only",
+			text:   "*bold* <https://example.com|*site*>\n<@U123>",
+			format: FormatLinks,
+			want:   `"*bold* " link("https://example.com", "*site*") br mention("@U123")`,
+		},
+		{
+			name:   "plain",
+			text:   "*bold* <https://example.com>\n\nline",
+			format: FormatPlain,
+			want:   `"*bold* <https://example.com>" br br "line"`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			if got := dump(ParseAs(test.text, test.format)); got != test.want {
+				t.Fatalf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "formatting is dropped",
+			text: "*bold* _italic_ `code`",
+			want: "bold italic code",
+		},
+		{
+			name: "link with label",
+			text: "<https://example.com|site>",
+			want: "site (https://example.com)",
+		},
+		{
+			name: "link without label",
+			text: "<https://example.com>",
+			want: "https://example.com",
+		},
+		{
+			name: "blocks on separate lines",
+			text: "text```code```text\n> quote",
+			want: "text\ncode\ntext\n> quote",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			if got := PlainText(Parse(test.text)); got != test.want {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestIsSafeURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{url: "https://example.com", want: true},
+		{url: "HTTP://example.com", want: true},
+		{url: "mailto:user@example.com", want: true},
+		{url: "magnet:?xt=urn:btih:abc", want: true},
+		{url: "javascript:alert(1)", want: false},
+		{url: "JavaScript:alert(1)", want: false},
+		{url: "data:text/html;base64,PHNjcmlwdD4=", want: false},
+		{url: "vbscript:msgbox", want: false},
+		{url: "relative/path", want: false},
+		{url: "http://[::1", want: false},
+	}
+
+	for _, test := range tests {
+		if got := IsSafeURL(test.url); got != test.want {
+			t.Errorf("IsSafeURL(%q) = %v, want %v", test.url, got, test.want)
+		}
+	}
+}
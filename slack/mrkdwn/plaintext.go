@@ -0,0 +1,67 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package mrkdwn
+
+import (
+	"strings"
+)
+
+// PlainText renders nodes as plain text, e.g. for clients which are
+// unable to display formatted messages.
+func PlainText(nodes []*Node) string {
+	var builder strings.Builder
+
+	writePlainText(&builder, nodes)
+
+	return strings.TrimSuffix(builder.String(), "\n")
+}
+
+func writePlainText(builder *strings.Builder, nodes []*Node) {
+	for _, node := range nodes {
+		// Blocks should start on new line.
+		if node.IsBlock() && builder.Len() != 0 && !strings.HasSuffix(builder.String(), "\n") {
+			builder.WriteString("\n")
+		}
+
+		switch node.Type {
+		case NodeText, NodeCode, NodeMention:
+			builder.WriteString(node.Text)
+		case NodeBold, NodeItalic, NodeStrike:
+			writePlainText(builder, node.Children)
+		case NodeCodeBlock:
+			builder.WriteString(node.Text)
+		case NodeQuote:
+			quote := PlainText(node.Children)
+			builder.WriteString("> " + strings.ReplaceAll(quote, "\n", "\n> "))
+		case NodeLink:
+			label := PlainText(node.Children)
+			if label == node.URL || label == strings.TrimPrefix(node.URL, "mailto:") {
+				builder.WriteString(label)
+			} else {
+				builder.WriteString(label + " (" + node.URL + ")")
+			}
+		case NodeLineBreak:
+			builder.WriteString("\n")
+		}
+
+		// Anything after block should start on new line too.
+		if node.IsBlock() {
+			builder.WriteString("\n")
+		}
+	}
+}
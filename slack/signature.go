@@ -0,0 +1,111 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package slack
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	configstruct "go.dev.pztrn.name/opensaps/config/struct"
+)
+
+// Slack request signature version. Signature is a "v0=" followed by
+// hex-encoded HMAC-SHA256 of "v0:TIMESTAMP:BODY".
+const signatureVersion = "v0"
+
+var (
+	errNotAuthenticated    = errors.New("request is neither signed nor contains shared secret")
+	errInvalidSharedSecret = errors.New("invalid shared secret")
+	errInvalidSignature    = errors.New("invalid signature")
+	errInvalidTimestamp    = errors.New("invalid request timestamp")
+	errTimestampExpired    = errors.New("request timestamp is outside of allowed window")
+)
+
+// Checks that request was sent by application that knows webhook's
+// signing or shared secret. Requests to webhooks without secrets are
+// always allowed.
+func (sh Handler) authenticate(req *http.Request, body []byte, cfg configstruct.ConfigWebhookSlack) error {
+	if !cfg.IsAuthRequired() {
+		return nil
+	}
+
+	if signature := req.Header.Get("X-Slack-Signature"); signature != "" && cfg.SigningSecret != "" {
+		return sh.verifySignature(signature, req.Header.Get("X-Slack-Request-Timestamp"), body, cfg.SigningSecret)
+	}
+
+	if secret := sh.getSharedSecret(req); secret != "" && cfg.SharedSecret != "" {
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(cfg.SharedSecret)) != 1 {
+			return errInvalidSharedSecret
+		}
+
+		return nil
+	}
+
+	return errNotAuthenticated
+}
+
+// Returns shared secret passed in X-OpenSAPS-Secret header or as bearer
+// token in Authorization header.
+func (sh Handler) getSharedSecret(req *http.Request) string {
+	if secret := req.Header.Get("X-OpenSAPS-Secret"); secret != "" {
+		return secret
+	}
+
+	if authorization := req.Header.Get("Authorization"); strings.HasPrefix(authorization, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+	}
+
+	return ""
+}
+
+// Verifies Slack request signature. Requests with timestamps too far from
+// current time are rejected, so captured requests can't be replayed later.
+func (sh Handler) verifySignature(signature string, timestamp string, body []byte, secret string) error {
+	// nolint:gomnd
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errInvalidTimestamp
+	}
+
+	age := time.Since(time.Unix(seconds, 0))
+	if age < 0 {
+		age = -age
+	}
+
+	if age > ctx.Config.GetConfig().SlackHandler.GetSignatureMaxAge() {
+		return errTimestampExpired
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(signatureVersion + ":" + timestamp + ":"))
+	_, _ = mac.Write(body)
+
+	expected := signatureVersion + "=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return errInvalidSignature
+	}
+
+	return nil
+}
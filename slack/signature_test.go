@@ -0,0 +1,188 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package slack
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	configurationinterface "go.dev.pztrn.name/opensaps/config/interface"
+	configstruct "go.dev.pztrn.name/opensaps/config/struct"
+	"go.dev.pztrn.name/opensaps/context"
+)
+
+// Configuration which returns passed structure. Other methods aren't
+// used by tests and will panic.
+type testConfiguration struct {
+	configurationinterface.ConfigurationInterface
+	cfg *configstruct.ConfigStruct
+}
+
+func (tc testConfiguration) GetConfig() *configstruct.ConfigStruct {
+	return tc.cfg
+}
+
+// Sets up package's context with passed configuration.
+func setupTestContext(t *testing.T, cfg *configstruct.ConfigStruct) {
+	t.Helper()
+
+	cfg.IndexWebhooks()
+
+	// nolint:exhaustruct
+	ctx = &context.Context{Config: testConfiguration{cfg: cfg}}
+}
+
+// Returns Slack signature of body signed at passed time.
+func sign(secret string, timestamp string, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte("v0:" + timestamp + ":" + body))
+
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestAuthenticate(t *testing.T) {
+	// nolint:exhaustruct
+	setupTestContext(t, &configstruct.ConfigStruct{})
+
+	const body = `{"text":"test"}`
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+
+	tests := []struct {
+		name    string
+		webhook configstruct.ConfigWebhookSlack
+		headers map[string]string
+		want    error
+	}{
+		{
+			name: "no secrets configured",
+		},
+		{
+			name:    "valid signature",
+			webhook: configstruct.ConfigWebhookSlack{SigningSecret: "signing"},
+			headers: map[string]string{
+				"X-Slack-Signature":         sign("signing", now, body),
+				"X-Slack-Request-Timestamp": now,
+			},
+		},
+		{
+			name:    "signature with wrong secret",
+			webhook: configstruct.ConfigWebhookSlack{SigningSecret: "signing"},
+			headers: map[string]string{
+				"X-Slack-Signature":         sign("other", now, body),
+				"X-Slack-Request-Timestamp": now,
+			},
+			want: errInvalidSignature,
+		},
+		{
+			name:    "signature for other timestamp",
+			webhook: configstruct.ConfigWebhookSlack{SigningSecret: "signing"},
+			headers: map[string]string{
+				"X-Slack-Signature":         sign("signing", now, body),
+				"X-Slack-Request-Timestamp": strconv.FormatInt(time.Now().Unix()-1, 10),
+			},
+			want: errInvalidSignature,
+		},
+		{
+			name:    "expired timestamp",
+			webhook: configstruct.ConfigWebhookSlack{SigningSecret: "signing"},
+			headers: map[string]string{
+				"X-Slack-Signature":         sign("signing", old, body),
+				"X-Slack-Request-Timestamp": old,
+			},
+			want: errTimestampExpired,
+		},
+		{
+			name:    "timestamp in future",
+			webhook: configstruct.ConfigWebhookSlack{SigningSecret: "signing"},
+			headers: map[string]string{
+				"X-Slack-Signature":         sign("signing", future, body),
+				"X-Slack-Request-Timestamp": future,
+			},
+			want: errTimestampExpired,
+		},
+		{
+			name:    "invalid timestamp",
+			webhook: configstruct.ConfigWebhookSlack{SigningSecret: "signing"},
+			headers: map[string]string{
+				"X-Slack-Signature":         sign("signing", "yesterday", body),
+				"X-Slack-Request-Timestamp": "yesterday",
+			},
+			want: errInvalidTimestamp,
+		},
+		{
+			name:    "unsigned request",
+			webhook: configstruct.ConfigWebhookSlack{SigningSecret: "signing"},
+			want:    errNotAuthenticated,
+		},
+		{
+			name:    "shared secret header",
+			webhook: configstruct.ConfigWebhookSlack{SharedSecret: "shared"},
+			headers: map[string]string{"X-OpenSAPS-Secret": "shared"},
+		},
+		{
+			name:    "shared secret bearer token",
+			webhook: configstruct.ConfigWebhookSlack{SharedSecret: "shared"},
+			headers: map[string]string{"Authorization": "Bearer shared"},
+		},
+		{
+			name:    "wrong shared secret",
+			webhook: configstruct.ConfigWebhookSlack{SharedSecret: "shared"},
+			headers: map[string]string{"X-OpenSAPS-Secret": "other"},
+			want:    errInvalidSharedSecret,
+		},
+		{
+			name:    "signature without signing secret",
+			webhook: configstruct.ConfigWebhookSlack{SharedSecret: "shared"},
+			headers: map[string]string{
+				"X-Slack-Signature":         sign("signing", now, body),
+				"X-Slack-Request-Timestamp": now,
+			},
+			want: errNotAuthenticated,
+		},
+		{
+			name:    "shared secret when both are configured",
+			webhook: configstruct.ConfigWebhookSlack{SigningSecret: "signing", SharedSecret: "shared"},
+			headers: map[string]string{"Authorization": "Bearer shared"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/T1/B2/secret", nil)
+			for name, value := range test.headers {
+				req.Header.Set(name, value)
+			}
+
+			err := (Handler{}).authenticate(req, []byte(body), test.webhook)
+			if !errors.Is(err, test.want) {
+				t.Fatalf("got %v, want %v", err, test.want)
+			}
+		})
+	}
+}
@@ -30,7 +30,9 @@ package slack
 
 import (
 	"context"
+	"net"
 	"net/http"
+	"sync/atomic"
 	"time"
 )
 
@@ -46,29 +48,140 @@ func (sh APIServer) Initialize() {
 	// Don't send pull requests, patches, don't create issues! :)
 	cfg := ctx.Config.GetConfig()
 
-	// nolint:exhaustruct,gomnd
-	httpsrv = &http.Server{
-		Addr: cfg.SlackHandler.Listener.Address,
-		// This handler will figure out from where request has come and will
-		// send it to appropriate pusher. Pusher should also determine to which
-		// connection data should be sent.
-		Handler:        Handler{},
-		ReadTimeout:    10 * time.Second,
-		WriteTimeout:   10 * time.Second,
-		MaxHeaderBytes: 1 << 20,
+	srv, err := sh.startServer(cfg.SlackHandler.Listener.Address, Handler{})
+	if err != nil {
+		ctx.Log.Fatal().Err(err).Str("address", cfg.SlackHandler.Listener.Address).Msg("Failed to start Slack Webhooks API server")
 	}
 
-	go func() {
-		_ = httpsrv.ListenAndServe()
-	}()
+	httpsrv = srv
+
+	atomic.StoreInt32(&listening, 1)
 
 	ctx.Log.Info().Str("address", cfg.SlackHandler.Listener.Address).Msg("Starting Slack Webhooks API server")
+
+	if cfg.SlackHandler.AdminListener.Address != "" {
+		srv, err := sh.startServer(cfg.SlackHandler.AdminListener.Address, AdminHandler{})
+		if err != nil {
+			ctx.Log.Fatal().Err(err).Str("address", cfg.SlackHandler.AdminListener.Address).Msg("Failed to start admin server")
+		}
+
+		adminsrv = srv
+
+		ctx.Log.Info().Str("address", cfg.SlackHandler.AdminListener.Address).Msg("Starting admin server")
+	}
+}
+
+// Restarts HTTP server if listener address was changed. Handler reads
+// configuration on every request, so nothing else should be done.
+func (sh APIServer) Reload() {
+	cfg := ctx.Config.GetConfig()
+
+	httpsrvMutex.Lock()
+	defer httpsrvMutex.Unlock()
+
+	sh.reloadAdminServer(cfg.SlackHandler.AdminListener.Address)
+
+	if httpsrv.Addr == cfg.SlackHandler.Listener.Address {
+		return
+	}
+
+	ctx.Log.Info().Str("old_address", httpsrv.Addr).Str("address", cfg.SlackHandler.Listener.Address).
+		Msg("Listener address was changed, restarting Slack Webhooks API server")
+
+	// New server should be started before old one will be stopped, so
+	// we can keep old one if something went wrong.
+	srv, err := sh.startServer(cfg.SlackHandler.Listener.Address, Handler{})
+	if err != nil {
+		ctx.Log.Error().Err(err).Str("address", cfg.SlackHandler.Listener.Address).
+			Msg("Failed to start Slack Webhooks API server on new address, will continue to use old one")
+
+		return
+	}
+
+	oldsrv := httpsrv
+	httpsrv = srv
+
+	// Shutdown waits for in-flight requests to complete.
+	_ = oldsrv.Shutdown(context.TODO())
+
+	ctx.Log.Info().Str("address", cfg.SlackHandler.Listener.Address).Msg("Slack Webhooks API server restarted")
 }
 
 func (sh APIServer) Shutdown() {
 	ctx.Log.Info().Msg("Shutting down Slack API handler...")
 
+	httpsrvMutex.Lock()
+	defer httpsrvMutex.Unlock()
+
+	atomic.StoreInt32(&listening, 0)
+
 	_ = httpsrv.Shutdown(context.TODO())
 
+	if adminsrv != nil {
+		_ = adminsrv.Shutdown(context.TODO())
+	}
+
 	ctx.Log.Info().Msg("Slack API HTTP server shutted down")
 }
+
+// Starts, stops or restarts admin server if its listener address was
+// changed. Should be called with httpsrvMutex locked.
+func (sh APIServer) reloadAdminServer(address string) {
+	if adminsrv != nil && adminsrv.Addr == address {
+		return
+	}
+
+	if adminsrv == nil && address == "" {
+		return
+	}
+
+	var (
+		srv *http.Server
+		err error
+	)
+
+	if address != "" {
+		srv, err = sh.startServer(address, AdminHandler{})
+		if err != nil {
+			ctx.Log.Error().Err(err).Str("address", address).Msg("Failed to start admin server on new address, will continue to use old one")
+
+			return
+		}
+
+		ctx.Log.Info().Str("address", address).Msg("Admin server started")
+	}
+
+	if adminsrv != nil {
+		_ = adminsrv.Shutdown(context.TODO())
+
+		ctx.Log.Info().Str("address", adminsrv.Addr).Msg("Admin server stopped")
+	}
+
+	adminsrv = srv
+}
+
+// Starts listening on passed address and serving requests in background.
+func (sh APIServer) startServer(address string, handler http.Handler) (*http.Server, error) {
+	// nolint:exhaustruct,gomnd
+	srv := &http.Server{
+		Addr: address,
+		// Slack Webhooks API handler will figure out from where request has
+		// come and will send it to appropriate pusher. Pusher should also
+		// determine to which connection data should be sent.
+		Handler:        handler,
+		ReadTimeout:    10 * time.Second,
+		WriteTimeout:   10 * time.Second,
+		MaxHeaderBytes: 1 << 20,
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		_ = srv.Serve(listener)
+	}()
+
+	return srv, nil
+}
@@ -19,12 +19,18 @@ package slack
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 
+	configstruct "go.dev.pztrn.name/opensaps/config/struct"
+	pusherinterface "go.dev.pztrn.name/opensaps/pushers/interface"
+	queueinterface "go.dev.pztrn.name/opensaps/queue/interface"
 	slackmessage "go.dev.pztrn.name/opensaps/slack/message"
 )
 
@@ -33,6 +39,20 @@ type Handler struct{}
 func (sh Handler) ServeHTTP(respwriter http.ResponseWriter, req *http.Request) {
 	ctx.Log.Debug().Str("method", req.Method).Str("host", req.Host).Str("path", req.URL.Path).Msg("Received HTTP request")
 
+	// Administrative endpoints are served by Slack Webhooks API server
+	// if separate listener wasn't configured for them.
+	if ctx.Config.GetConfig().SlackHandler.AdminListener.Address == "" && (AdminHandler{}).Handles(req) {
+		AdminHandler{}.ServeHTTP(respwriter, req)
+
+		return
+	}
+
+	if strings.HasPrefix(req.URL.Path, webAPIPrefix) {
+		WebAPIHandler{}.ServeHTTP(respwriter, req)
+
+		return
+	}
+
 	// We should catch only POST requests. Otherwise return HTTP 404.
 	if req.Method != "POST" {
 		ctx.Log.Debug().Msg("Not a POST request, returning HTTP 404")
@@ -42,62 +62,189 @@ func (sh Handler) ServeHTTP(respwriter http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// Try to figure out where we should push received data.
+	cfg := ctx.Config.GetConfig()
+
+	team, bot, secret, valid := sh.parseWebhookPath(req.URL.Path, cfg.SlackHandler.GetPathPrefixes())
+	if !valid {
+		ctx.Log.Debug().Msg("Path doesn't look like webhook URL, returning HTTP 404")
+		respwriter.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(respwriter, "NOT FOUND")
+
+		return
+	}
+
+	name, found := cfg.FindWebhook(team, bot, secret)
+	if !found {
+		ctx.Log.Debug().Msg("Don't know where to push data. Ignoring with HTTP 404")
+		respwriter.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(respwriter, "NOT FOUND")
+
+		return
+	}
+
+	config := cfg.Webhooks[name]
+
+	ctx.Log.Debug().Str("webhook", name).Int("destinations", len(config.Remote)).Msg("Passed data belongs to webhook")
+
 	body, _ := ioutil.ReadAll(req.Body)
 	req.Body.Close()
 
 	ctx.Log.Debug().Msgf("Received body: %s", string(body))
 
-	// Try to figure out where we should push received data.
-	cfg := ctx.Config.GetConfig()
+	ctx.Metrics.RequestReceived(name)
+
+	if err := sh.authenticate(req, body, config.Slack); err != nil {
+		ctx.Log.Warn().Err(err).Str("webhook", name).Str("remote", req.RemoteAddr).
+			Msg("Rejecting unauthenticated request")
+		respwriter.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintf(respwriter, "unauthorized")
+
+		return
+	}
+
+	slackmsg, err := sh.decodeMessage(body)
+	if err != nil {
+		ctx.Metrics.ParseFailed(name)
+		ctx.Log.Error().Err(err).Str("webhook", name).Msg("Failed to decode received data")
+		respwriter.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(respwriter, "invalid_payload")
+
+		return
+	}
 
-	var sentToPusher bool
-
-	for name, config := range cfg.Webhooks {
-		if strings.Contains(req.URL.Path, config.Slack.Random1) &&
-			strings.Contains(req.URL.Path, config.Slack.Random2) &&
-			strings.Contains(req.URL.Path, config.Slack.LongRandom) {
-			ctx.Log.Debug().Msgf("Passed data belongs to '%s' and should go to '%s' pusher, protocol '%s'",
-				name, config.Remote.PushTo, config.Remote.Pusher)
-			// Parse message into SlackMessage structure.
-			if strings.Contains(string(body)[0:7], "payload") {
-				// We have HTTP form payload. It still should be a
-				// parseable JSON string, we just need to do some
-				// preparations.
-				// First - remove "payload=" from the beginning.
-				tempBody := string(body)
-				tempBody = strings.Replace(tempBody, "payload=", "", 1)
-				// Second - unescape data.
-				tempBody, err := url.QueryUnescape(tempBody)
-				if err != nil {
-					ctx.Log.Error().Msg("Failed to decode body into parseable string!")
-
-					return
-				}
-
-				// And finally - convert body back to bytes.
-				body = []byte(tempBody)
-			}
-
-			// nolint:exhaustruct
-			slackmsg := slackmessage.SlackMessage{}
-
-			err := json.Unmarshal(body, &slackmsg)
-			if err != nil {
-				ctx.Log.Error().Err(err).Msg("Failed to decode JSON into SlackMessage struct")
-
-				return
-			}
-
-			ctx.Log.Debug().Msgf("Received message: %+v", slackmsg)
-			ctx.SendToPusher(config.Remote.Pusher, config.Remote.PushTo, slackmsg)
-
-			sentToPusher = true
+	ctx.Log.Debug().Msgf("Received message: %+v", slackmsg)
+
+	deliveryErrors := sh.dispatch(name, config.Remote, queueinterface.ActionPost, slackmsg, "")
+
+	if len(deliveryErrors) != 0 {
+		status := sh.errorsToStatusCode(deliveryErrors)
+
+		ctx.Log.Debug().Int("status", status).Int("failed", len(deliveryErrors)).Msg("Reporting failed delivery to sender")
+		setRetryAfter(respwriter, status)
+		respwriter.WriteHeader(status)
+
+		for _, err := range deliveryErrors {
+			fmt.Fprintln(respwriter, ctx.Redact(err.Error()))
 		}
+
+		return
 	}
 
-	if !sentToPusher {
-		ctx.Log.Debug().Msg("Don't know where to push data. Ignoring with HTTP 404")
-		respwriter.WriteHeader(http.StatusNotFound)
-		fmt.Fprintf(respwriter, "NOT FOUND")
+	fmt.Fprintf(respwriter, "ok")
+}
+
+// Extracts team, bot and secret parts from webhook URL path, which looks
+// like "PREFIX/T12345678/B87654321/24charslongstring". Returns false if
+// path doesn't look like webhook URL.
+func (sh Handler) parseWebhookPath(path string, prefixes []string) (string, string, string, bool) {
+	for _, prefix := range prefixes {
+		if !strings.HasPrefix(path, prefix+"/") {
+			continue
+		}
+
+		parts := strings.Split(strings.TrimPrefix(path, prefix+"/"), "/")
+
+		// nolint:gomnd
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			continue
+		}
+
+		return parts[0], parts[1], parts[2], true
+	}
+
+	return "", "", "", false
+}
+
+// Parses received body into SlackMessage structure.
+func (sh Handler) decodeMessage(body []byte) (slackmessage.SlackMessage, error) {
+	// nolint:exhaustruct
+	slackmsg := slackmessage.SlackMessage{}
+
+	if strings.HasPrefix(string(body), "payload") {
+		// We have HTTP form payload. It still should be a
+		// parseable JSON string, we just need to do some
+		// preparations.
+		// First - remove "payload=" from the beginning.
+		tempBody := string(body)
+		tempBody = strings.Replace(tempBody, "payload=", "", 1)
+		// Second - unescape data.
+		tempBody, err := url.QueryUnescape(tempBody)
+		if err != nil {
+			return slackmsg, fmt.Errorf("failed to decode body into parseable string: %w", err)
+		}
+
+		// And finally - convert body back to bytes.
+		body = []byte(tempBody)
+	}
+
+	if err := json.Unmarshal(body, &slackmsg); err != nil {
+		return slackmsg, fmt.Errorf("failed to decode JSON into SlackMessage struct: %w", err)
+	}
+
+	return slackmsg, nil
+}
+
+// Queues message for every destination configured for webhook. Returns
+// error if message can't be queued for some destination, in this case it
+// isn't queued for any of them. If Slack message timestamp is passed, IDs
+// of created messages will be stored in messages map after delivery.
+func (sh Handler) dispatch(webhook string, remotes configstruct.ConfigWebhookRemotes, action string,
+	message slackmessage.SlackMessage, ts string) []error {
+	if len(remotes) == 0 {
+		ctx.Log.Warn().Str("webhook", webhook).Msg("Webhook has no destinations configured, nothing to push")
+
+		return nil
+	}
+
+	// Messages are delivered by queue's workers, we only need to make
+	// sure they were queued.
+	ids, err := ctx.Queue.Enqueue(webhook, remotes, action, message, ts)
+	if err != nil {
+		ctx.Log.Error().Err(err).Str("webhook", webhook).Msg("Failed to queue data")
+
+		return []error{err}
+	}
+
+	for idx, remote := range remotes {
+		ctx.Log.Info().Str("webhook", webhook).Int("destination", idx).Str("pusher", remote.Pusher).
+			Str("conn", remote.PushTo).Str("id", ids[idx]).Msg("Data queued for destination")
+	}
+
+	return nil
+}
+
+// Asks sender to retry later if message wasn't accepted because delivery
+// queue is full or remote service is unavailable.
+func setRetryAfter(respwriter http.ResponseWriter, status int) {
+	if status != http.StatusServiceUnavailable {
+		return
+	}
+
+	// Queue will try to deliver something in this time.
+	retryAfter := int(math.Ceil(ctx.Config.GetConfig().Queue.GetInitialBackoff().Seconds()))
+	respwriter.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+}
+
+// Figures out HTTP status code to reply with for sending application.
+// Permanent errors take precedence over temporary ones, as there is
+// no point in re-sending message that will never be delivered.
+func (sh Handler) errorsToStatusCode(errs []error) int {
+	status := http.StatusServiceUnavailable
+
+	for _, err := range errs {
+		switch {
+		case errors.Is(err, pusherinterface.ErrPusherNotFound), errors.Is(err, pusherinterface.ErrConnectionNotFound),
+			errors.Is(err, queueinterface.ErrEnqueueFailed):
+			// Misconfiguration or failure on our side.
+			return http.StatusInternalServerError
+		case pusherinterface.IsTemporary(err), errors.Is(err, queueinterface.ErrQueueFull):
+			continue
+		default:
+			// Remote service rejected our message.
+			status = http.StatusBadGateway
+		}
 	}
+
+	return status
 }
@@ -0,0 +1,132 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package slack
+
+import (
+	"testing"
+
+	configstruct "go.dev.pztrn.name/opensaps/config/struct"
+)
+
+func TestParseWebhookPath(t *testing.T) {
+	defaultPrefixes := configstruct.ConfigSlackHandler{}.GetPathPrefixes()
+
+	tests := []struct {
+		name     string
+		path     string
+		prefixes []string
+		want     []string
+	}{
+		{
+			name:     "services prefix",
+			path:     "/services/T1111/B2222/secret",
+			prefixes: defaultPrefixes,
+			want:     []string{"T1111", "B2222", "secret"},
+		},
+		{
+			name:     "root prefix",
+			path:     "/T1111/B2222/secret",
+			prefixes: defaultPrefixes,
+			want:     []string{"T1111", "B2222", "secret"},
+		},
+		{
+			name:     "custom prefix",
+			path:     "/hooks/slack/T1111/B2222/secret",
+			prefixes: configstruct.ConfigSlackHandler{PathPrefixes: []string{"/hooks/slack/"}}.GetPathPrefixes(),
+			want:     []string{"T1111", "B2222", "secret"},
+		},
+		{
+			name:     "path outside of custom prefix",
+			path:     "/services/T1111/B2222/secret",
+			prefixes: configstruct.ConfigSlackHandler{PathPrefixes: []string{"/hooks"}}.GetPathPrefixes(),
+		},
+		{
+			name:     "too few parts",
+			path:     "/T1111/B2222",
+			prefixes: defaultPrefixes,
+		},
+		{
+			name:     "too many parts",
+			path:     "/services/T1111/B2222/secret/extra",
+			prefixes: defaultPrefixes,
+		},
+		{
+			name:     "empty part",
+			path:     "/services/T1111//secret",
+			prefixes: defaultPrefixes,
+		},
+		{
+			name:     "trailing slash",
+			path:     "/services/T1111/B2222/secret/",
+			prefixes: defaultPrefixes,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			team, bot, secret, valid := (Handler{}).parseWebhookPath(test.path, test.prefixes)
+
+			if valid != (test.want != nil) {
+				t.Fatalf("got valid %v for %q", valid, test.path)
+			}
+
+			if valid && (team != test.want[0] || bot != test.want[1] || secret != test.want[2]) {
+				t.Fatalf("got %q, %q, %q, want %q", team, bot, secret, test.want)
+			}
+		})
+	}
+}
+
+func TestFindWebhook(t *testing.T) {
+	// nolint:exhaustruct
+	cfg := &configstruct.ConfigStruct{
+		Webhooks: map[string]configstruct.ConfigWebhook{
+			"short": {Slack: configstruct.ConfigWebhookSlack{Random1: "1111", Random2: "2222", LongRandom: "short-secret"}},
+			"full":  {Slack: configstruct.ConfigWebhookSlack{Random1: "T3333", Random2: "B4444", LongRandom: "full-secret"}},
+		},
+	}
+	cfg.IndexWebhooks()
+
+	tests := []struct {
+		name   string
+		team   string
+		bot    string
+		secret string
+		want   string
+	}{
+		{name: "prefixes omitted in configuration and path", team: "1111", bot: "2222", secret: "short-secret", want: "short"},
+		{name: "prefixes omitted in configuration", team: "T1111", bot: "B2222", secret: "short-secret", want: "short"},
+		{name: "prefixes in configuration and path", team: "T3333", bot: "B4444", secret: "full-secret", want: "full"},
+		{name: "prefixes omitted in path", team: "3333", bot: "4444", secret: "full-secret"},
+		{name: "wrong secret", team: "T1111", bot: "B2222", secret: "full-secret"},
+		{name: "parts of other webhook", team: "T1111", bot: "B4444", secret: "short-secret"},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			name, found := cfg.FindWebhook(test.team, test.bot, test.secret)
+			if found != (test.want != "") || name != test.want {
+				t.Fatalf("got %q (found %v), want %q", name, found, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,378 @@
+// OpenSAPS - Open Slack API server for everyone.
+//
+// Copyright (c) 2017, Stanislav N. aka pztrn.
+// All rights reserved.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+package slack
+
+// This is an emulation of Slack Web API for tools which can't use
+// webhooks. Requests are authenticated with bot tokens, channels are
+// mapped to destinations in configuration.
+//
+// As real Slack does, HTTP 200 is returned for most of errors, with
+// error code in reply's JSON.
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	configstruct "go.dev.pztrn.name/opensaps/config/struct"
+	queueinterface "go.dev.pztrn.name/opensaps/queue/interface"
+	slackmessage "go.dev.pztrn.name/opensaps/slack/message"
+)
+
+// Slack Web API methods are served under this path.
+const webAPIPrefix = "/api/"
+
+// Request to Slack Web API method.
+type webAPIRequest struct {
+	// Bot's name from configuration.
+	bot     string
+	botCfg  configstruct.ConfigWebAPIBot
+	message slackmessage.SlackMessage
+	// Timestamp of message to update or delete.
+	ts string
+}
+
+// Web API method handler. Returns HTTP status code and reply.
+type webAPIMethod func(req *webAPIRequest) (int, map[string]interface{})
+
+// WebAPIHandler handles Slack Web API requests.
+type WebAPIHandler struct{}
+
+func (wah WebAPIHandler) ServeHTTP(respwriter http.ResponseWriter, req *http.Request) {
+	methods := map[string]webAPIMethod{
+		"auth.test":        wah.authTest,
+		"chat.delete":      wah.chatDelete,
+		"chat.postMessage": wah.chatPostMessage,
+		"chat.update":      wah.chatUpdate,
+	}
+
+	methodName := strings.Trim(strings.TrimPrefix(req.URL.Path, webAPIPrefix), "/")
+
+	method, found := methods[methodName]
+	if !found {
+		ctx.Log.Debug().Str("method", methodName).Msg("Unknown Web API method requested")
+		wah.reply(respwriter, http.StatusOK, wah.error("unknown_method"))
+
+		return
+	}
+
+	if req.Method != http.MethodPost {
+		wah.reply(respwriter, http.StatusMethodNotAllowed, wah.error("method_not_supported_for_channel_type"))
+
+		return
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+
+	if err != nil {
+		wah.reply(respwriter, http.StatusBadRequest, wah.error("invalid_form_data"))
+
+		return
+	}
+
+	apiReq, token, err := wah.decodeRequest(req, body)
+	if err != nil {
+		ctx.Log.Error().Err(err).Str("method", methodName).Msg("Failed to decode Web API request")
+		wah.reply(respwriter, http.StatusOK, wah.error("invalid_arguments"))
+
+		return
+	}
+
+	if token == "" {
+		wah.reply(respwriter, http.StatusOK, wah.error("not_authed"))
+
+		return
+	}
+
+	apiReq.bot, apiReq.botCfg, found = wah.findBot(token)
+	if !found {
+		ctx.Log.Debug().Str("method", methodName).Msg("Web API request with unknown token")
+		wah.reply(respwriter, http.StatusOK, wah.error("invalid_auth"))
+
+		return
+	}
+
+	ctx.Log.Debug().Str("method", methodName).Str("bot", apiReq.bot).Msg("Received Web API request")
+
+	// Bot's requests are counted like webhook's ones, whatever method is
+	// called.
+	ctx.Metrics.RequestReceived("webapi/" + apiReq.bot)
+
+	status, reply := method(apiReq)
+	wah.reply(respwriter, status, reply)
+}
+
+// Replies to token check requests, which some tools use to verify
+// configuration.
+func (wah WebAPIHandler) authTest(req *webAPIRequest) (int, map[string]interface{}) {
+	return http.StatusOK, map[string]interface{}{
+		"ok":      true,
+		"url":     "https://opensaps/",
+		"team":    "OpenSAPS",
+		"user":    req.bot,
+		"team_id": "T00000000",
+		"user_id": "U00000000",
+		"bot_id":  "B00000000",
+	}
+}
+
+// Deletes messages created for Slack message. Deletion is queued, so
+// messages which are still waiting in delivery queue will be deleted
+// right after they will be sent.
+func (wah WebAPIHandler) chatDelete(req *webAPIRequest) (int, map[string]interface{}) {
+	remotes, errCode := wah.findMessage(req)
+	if errCode != "" {
+		return http.StatusOK, wah.error(errCode)
+	}
+
+	if errs := (Handler{}).dispatch("webapi/"+req.bot, remotes, queueinterface.ActionDelete, req.message,
+		req.ts); len(errs) != 0 {
+		return wah.deliveryError(errs)
+	}
+
+	ctx.MessageMap.MarkDeleted(req.ts)
+
+	return http.StatusOK, map[string]interface{}{
+		"ok":      true,
+		"channel": req.message.Channel,
+		"ts":      req.ts,
+	}
+}
+
+func (wah WebAPIHandler) chatPostMessage(req *webAPIRequest) (int, map[string]interface{}) {
+	message := req.message
+	webhook := "webapi/" + req.bot
+
+	if message.Channel == "" {
+		return http.StatusOK, wah.error("channel_not_found")
+	}
+
+	if wah.isEmpty(message) {
+		return http.StatusOK, wah.error("no_text")
+	}
+
+	remotes, found := req.botCfg.GetChannel(message.Channel)
+	if !found {
+		ctx.Log.Debug().Str("bot", req.bot).Str("channel", message.Channel).Msg("Channel isn't configured for bot")
+
+		return http.StatusOK, wah.error("channel_not_found")
+	}
+
+	// Message should be registered before delivery, as it might be
+	// delivered by queue before dispatching will finish.
+	ts := generateTS()
+	ctx.MessageMap.Register(ts, req.bot, message.Channel)
+
+	if errs := (Handler{}).dispatch(webhook, remotes, queueinterface.ActionPost, message, ts); len(errs) != 0 {
+		// Sender won't know timestamp, so message can't be updated anyway.
+		ctx.MessageMap.Remove(ts)
+
+		return wah.deliveryError(errs)
+	}
+
+	reply := map[string]interface{}{
+		"type":     "message",
+		"subtype":  "bot_message",
+		"text":     message.Text,
+		"username": message.Username,
+		"ts":       ts,
+	}
+
+	if message.ThreadTS != "" {
+		reply["thread_ts"] = message.ThreadTS
+	}
+
+	return http.StatusOK, map[string]interface{}{
+		"ok":      true,
+		"channel": message.Channel,
+		"ts":      ts,
+		"message": reply,
+	}
+}
+
+// Updates messages created for Slack message. Update is queued like
+// deletion, see chatDelete().
+func (wah WebAPIHandler) chatUpdate(req *webAPIRequest) (int, map[string]interface{}) {
+	if wah.isEmpty(req.message) {
+		return http.StatusOK, wah.error("no_text")
+	}
+
+	remotes, errCode := wah.findMessage(req)
+	if errCode != "" {
+		return http.StatusOK, wah.error(errCode)
+	}
+
+	if errs := (Handler{}).dispatch("webapi/"+req.bot, remotes, queueinterface.ActionUpdate, req.message,
+		req.ts); len(errs) != 0 {
+		return wah.deliveryError(errs)
+	}
+
+	return http.StatusOK, map[string]interface{}{
+		"ok":      true,
+		"channel": req.message.Channel,
+		"ts":      req.ts,
+		"text":    req.message.Text,
+	}
+}
+
+// Decodes request and returns it with passed token. Web API accepts
+// both JSON and form encoded requests.
+func (wah WebAPIHandler) decodeRequest(req *http.Request, body []byte) (*webAPIRequest, string, error) {
+	// nolint:exhaustruct
+	payload := struct {
+		slackmessage.SlackMessage
+		Token string `json:"token"`
+		TS    string `json:"ts"`
+	}{}
+
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, "", fmt.Errorf("failed to decode JSON: %w", err)
+		}
+	} else {
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to decode form: %w", err)
+		}
+
+		payload.Token = values.Get("token")
+		payload.TS = values.Get("ts")
+		payload.Channel = values.Get("channel")
+		payload.Text = values.Get("text")
+		payload.ThreadTS = values.Get("thread_ts")
+		payload.Username = values.Get("username")
+		payload.IconURL = values.Get("icon_url")
+
+		// Attachments and blocks are passed as JSON strings.
+		if attachments := values.Get("attachments"); attachments != "" {
+			if err := json.Unmarshal([]byte(attachments), &payload.Attachments); err != nil {
+				return nil, "", fmt.Errorf("failed to decode attachments: %w", err)
+			}
+		}
+
+		if blocks := values.Get("blocks"); blocks != "" {
+			if err := json.Unmarshal([]byte(blocks), &payload.Blocks); err != nil {
+				return nil, "", fmt.Errorf("failed to decode blocks: %w", err)
+			}
+		}
+	}
+
+	token := payload.Token
+	if authorization := req.Header.Get("Authorization"); strings.HasPrefix(authorization, "Bearer ") {
+		token = strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+	}
+
+	// nolint:exhaustruct
+	return &webAPIRequest{message: payload.SlackMessage, ts: payload.TS}, token, nil
+}
+
+// Converts delivery errors into reply.
+func (wah WebAPIHandler) deliveryError(errs []error) (int, map[string]interface{}) {
+	status := (Handler{}).errorsToStatusCode(errs)
+
+	code := "delivery_failed"
+
+	switch status {
+	case http.StatusInternalServerError:
+		code = "internal_error"
+	case http.StatusServiceUnavailable:
+		code = "service_unavailable"
+	}
+
+	return status, wah.error(code)
+}
+
+func (wah WebAPIHandler) error(code string) map[string]interface{} {
+	return map[string]interface{}{"ok": false, "error": code}
+}
+
+// Returns name and configuration of bot with passed token.
+func (wah WebAPIHandler) findBot(token string) (string, configstruct.ConfigWebAPIBot, bool) {
+	for name, bot := range ctx.Config.GetConfig().WebAPI.Bots {
+		if subtle.ConstantTimeCompare([]byte(bot.Token), []byte(token)) == 1 {
+			return name, bot, true
+		}
+	}
+
+	// nolint:exhaustruct
+	return "", configstruct.ConfigWebAPIBot{}, false
+}
+
+// Returns previously sent message which should be updated or deleted.
+// Bots can change only messages they sent themselves. Returns Slack
+// error code if message can't be found.
+func (wah WebAPIHandler) findMessage(req *webAPIRequest) (configstruct.ConfigWebhookRemotes, string) {
+	if req.message.Channel == "" {
+		return nil, "channel_not_found"
+	}
+
+	entry, found := ctx.MessageMap.Get(req.ts)
+	if !found || entry.Deleted || entry.Bot != req.bot ||
+		configstruct.NormalizeChannel(entry.Channel) != configstruct.NormalizeChannel(req.message.Channel) {
+		ctx.Log.Debug().Str("bot", req.bot).Str("channel", req.message.Channel).Str("ts", req.ts).
+			Msg("Message to change wasn't found")
+
+		return nil, "message_not_found"
+	}
+
+	// Message is changed in destinations it was sent to.
+	remotes, found := req.botCfg.GetChannel(entry.Channel)
+	if !found || len(remotes) == 0 {
+		return nil, "channel_not_found"
+	}
+
+	return remotes, ""
+}
+
+func (wah WebAPIHandler) isEmpty(message slackmessage.SlackMessage) bool {
+	return message.Text == "" && len(message.Attachments) == 0 && len(message.Blocks) == 0
+}
+
+func (wah WebAPIHandler) reply(respwriter http.ResponseWriter, status int, data map[string]interface{}) {
+	body, _ := json.Marshal(data)
+
+	respwriter.Header().Set("Content-Type", "application/json; charset=utf-8")
+	setRetryAfter(respwriter, status)
+	respwriter.WriteHeader(status)
+	_, _ = respwriter.Write(body)
+}
+
+// Generates unique Slack message timestamp ("seconds.microseconds").
+func generateTS() string {
+	for {
+		last := atomic.LoadInt64(&lastTS)
+
+		// nolint:gomnd
+		now := time.Now().UnixNano() / 1000
+		if now <= last {
+			now = last + 1
+		}
+
+		if atomic.CompareAndSwapInt64(&lastTS, last, now) {
+			// nolint:gomnd
+			return fmt.Sprintf("%d.%06d", now/1000000, now%1000000)
+		}
+	}
+}